@@ -0,0 +1,351 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/joerivrij/microbases/shared/models"
+	"github.com/joerivrij/microbases/shared/response"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	openlog "github.com/opentracing/opentracing-go/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const ANNOTATIONS = "annotations"
+
+// AnnotationBody is what clients send to write an annotation, the rest is filled in by the service
+type AnnotationBody struct {
+	From int      `json:"from"`
+	To   int      `json:"to"`
+	Text string   `json:"text"`
+	Tags []string `json:"tags"`
+}
+
+// AnnotatedCanto is a canto with every annotation made on it, annotations point to their verses with from and to
+type AnnotatedCanto struct {
+	Book        string              `json:"book"`
+	Canto       int                 `json:"canto"`
+	Verses      []models.Canto      `json:"verses"`
+	Annotations []models.Annotation `json:"annotations"`
+}
+
+func createAnnotationHandler(w http.ResponseWriter, req *http.Request) {
+	spanCtx, _ := opentracing.GlobalTracer().Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(req.Header))
+	span := opentracing.GlobalTracer().StartSpan("createAnnotationHandler", ext.RPCServerOption(spanCtx))
+	defer span.Finish()
+
+	ctx := opentracing.ContextWithSpan(req.Context(), span)
+
+	span.LogFields(
+		openlog.String("method", req.Method),
+		openlog.String("path", req.URL.Path),
+		openlog.String("host", req.Host),
+	)
+
+	author, ok := requireAuthor(w, req, ctx)
+	if !ok {
+		return
+	}
+
+	book, arabic, err := parseCantoVars(mux.Vars(req))
+	if err != nil {
+		response.RespondWithError(w, http.StatusBadRequest, err.Error(), ctx)
+		return
+	}
+
+	now := time.Now().UTC()
+	annotation := models.Annotation{
+		ID:       primitive.NewObjectID(),
+		Book:     book,
+		Arabic:   arabic,
+		Author:   author,
+		Created:  now,
+		Modified: now,
+	}
+	if !decodeAnnotation(w, req, ctx, &annotation) {
+		return
+	}
+
+	if err := annotations.Insert(ctx, annotation); err != nil {
+		respondWithStoreError(w, err, ctx)
+		return
+	}
+
+	span.LogFields(
+		openlog.String("annotation", annotation.ID.Hex()),
+		openlog.String("author", author),
+	)
+
+	w.Header().Set("Location", "/api/annotations/"+annotation.ID.Hex())
+	response.RespondWithJson(w, http.StatusCreated, annotation, ctx)
+}
+
+func cantoAnnotationsHandler(w http.ResponseWriter, req *http.Request) {
+	spanCtx, _ := opentracing.GlobalTracer().Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(req.Header))
+	span := opentracing.GlobalTracer().StartSpan("cantoAnnotationsHandler", ext.RPCServerOption(spanCtx))
+	defer span.Finish()
+
+	ctx := opentracing.ContextWithSpan(req.Context(), span)
+
+	span.LogFields(
+		openlog.String("method", req.Method),
+		openlog.String("path", req.URL.Path),
+		openlog.String("host", req.Host),
+	)
+
+	book, arabic, err := parseCantoVars(mux.Vars(req))
+	if err != nil {
+		response.RespondWithError(w, http.StatusBadRequest, err.Error(), ctx)
+		return
+	}
+
+	filter, err := parseAnnotationFilter(req)
+	if err != nil {
+		response.RespondWithError(w, http.StatusBadRequest, err.Error(), ctx)
+		return
+	}
+
+	found, err := annotations.FindByCanto(ctx, book, arabic, filter)
+	if err != nil {
+		respondWithStoreError(w, err, ctx)
+		return
+	}
+
+	response.RespondWithJson(w, http.StatusOK, found, ctx)
+}
+
+func annotatedCantoHandler(w http.ResponseWriter, req *http.Request) {
+	spanCtx, _ := opentracing.GlobalTracer().Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(req.Header))
+	span := opentracing.GlobalTracer().StartSpan("annotatedCantoHandler", ext.RPCServerOption(spanCtx))
+	defer span.Finish()
+
+	ctx := opentracing.ContextWithSpan(req.Context(), span)
+
+	span.LogFields(
+		openlog.String("method", req.Method),
+		openlog.String("path", req.URL.Path),
+		openlog.String("host", req.Host),
+	)
+
+	book, arabic, err := parseCantoVars(mux.Vars(req))
+	if err != nil {
+		response.RespondWithError(w, http.StatusBadRequest, err.Error(), ctx)
+		return
+	}
+
+	filter, err := parseAnnotationFilter(req)
+	if err != nil {
+		response.RespondWithError(w, http.StatusBadRequest, err.Error(), ctx)
+		return
+	}
+
+	verses, _, err := repo.FindByCanto(ctx, book, arabic, ListOptions{Sort: []string{"verse"}})
+	if err != nil {
+		respondWithStoreError(w, err, ctx)
+		return
+	}
+	if len(verses) == 0 {
+		response.RespondWithError(w, http.StatusNotFound, fmt.Sprintf("%s canto %d not found", book, arabic), ctx)
+		return
+	}
+
+	found, err := annotations.FindByCanto(ctx, book, arabic, filter)
+	if err != nil {
+		respondWithStoreError(w, err, ctx)
+		return
+	}
+
+	span.LogFields(
+		openlog.Int("annotations", len(found)),
+	)
+
+	response.RespondWithJson(w, http.StatusOK, AnnotatedCanto{
+		Book:        book,
+		Canto:       arabic,
+		Verses:      verses,
+		Annotations: found,
+	}, ctx)
+}
+
+func annotationHandler(w http.ResponseWriter, req *http.Request) {
+	spanCtx, _ := opentracing.GlobalTracer().Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(req.Header))
+	span := opentracing.GlobalTracer().StartSpan("annotationHandler", ext.RPCServerOption(spanCtx))
+	defer span.Finish()
+
+	ctx := opentracing.ContextWithSpan(req.Context(), span)
+
+	span.LogFields(
+		openlog.String("method", req.Method),
+		openlog.String("path", req.URL.Path),
+		openlog.String("host", req.Host),
+	)
+
+	annotation, ok := findAnnotation(w, req, ctx)
+	if !ok {
+		return
+	}
+
+	response.RespondWithJson(w, http.StatusOK, annotation, ctx)
+}
+
+// replaceAnnotationHandler changes the text, tags or verses of an annotation, only its author can
+func replaceAnnotationHandler(w http.ResponseWriter, req *http.Request) {
+	spanCtx, _ := opentracing.GlobalTracer().Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(req.Header))
+	span := opentracing.GlobalTracer().StartSpan("replaceAnnotationHandler", ext.RPCServerOption(spanCtx))
+	defer span.Finish()
+
+	ctx := opentracing.ContextWithSpan(req.Context(), span)
+
+	span.LogFields(
+		openlog.String("method", req.Method),
+		openlog.String("path", req.URL.Path),
+		openlog.String("host", req.Host),
+	)
+
+	author, ok := requireAuthor(w, req, ctx)
+	if !ok {
+		return
+	}
+
+	annotation, ok := findAnnotation(w, req, ctx)
+	if !ok {
+		return
+	}
+	if annotation.Author != author {
+		response.RespondWithError(w, http.StatusForbidden, "only the author can change an annotation", ctx)
+		return
+	}
+
+	if !decodeAnnotation(w, req, ctx, &annotation) {
+		return
+	}
+	annotation.Modified = time.Now().UTC()
+
+	err := annotations.Replace(ctx, annotation)
+	if err == ErrNotFound {
+		response.RespondWithError(w, http.StatusNotFound, "annotation not found", ctx)
+		return
+	}
+	if err != nil {
+		respondWithStoreError(w, err, ctx)
+		return
+	}
+
+	response.RespondWithJson(w, http.StatusOK, annotation, ctx)
+}
+
+func deleteAnnotationHandler(w http.ResponseWriter, req *http.Request) {
+	spanCtx, _ := opentracing.GlobalTracer().Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(req.Header))
+	span := opentracing.GlobalTracer().StartSpan("deleteAnnotationHandler", ext.RPCServerOption(spanCtx))
+	defer span.Finish()
+
+	ctx := opentracing.ContextWithSpan(req.Context(), span)
+
+	span.LogFields(
+		openlog.String("method", req.Method),
+		openlog.String("path", req.URL.Path),
+		openlog.String("host", req.Host),
+	)
+
+	author, ok := requireAuthor(w, req, ctx)
+	if !ok {
+		return
+	}
+
+	annotation, ok := findAnnotation(w, req, ctx)
+	if !ok {
+		return
+	}
+	if annotation.Author != author {
+		response.RespondWithError(w, http.StatusForbidden, "only the author can delete an annotation", ctx)
+		return
+	}
+
+	err := annotations.Delete(ctx, annotation.ID)
+	if err == ErrNotFound {
+		response.RespondWithError(w, http.StatusNotFound, "annotation not found", ctx)
+		return
+	}
+	if err != nil {
+		respondWithStoreError(w, err, ctx)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func findAnnotation(w http.ResponseWriter, req *http.Request, ctx context.Context) (models.Annotation, bool) {
+	id, err := primitive.ObjectIDFromHex(mux.Vars(req)["annotation"])
+	if err != nil {
+		response.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("annotation %q is not a valid id", mux.Vars(req)["annotation"]), ctx)
+		return models.Annotation{}, false
+	}
+
+	annotation, err := annotations.Find(ctx, id)
+	if err == ErrNotFound {
+		response.RespondWithError(w, http.StatusNotFound, "annotation not found", ctx)
+		return annotation, false
+	}
+	if err != nil {
+		respondWithStoreError(w, err, ctx)
+		return annotation, false
+	}
+	return annotation, true
+}
+
+// decodeAnnotation reads the body onto the annotation and checks that the verses it is about exist
+func decodeAnnotation(w http.ResponseWriter, req *http.Request, ctx context.Context, annotation *models.Annotation) bool {
+	var body AnnotationBody
+	decoder := json.NewDecoder(req.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&body); err != nil {
+		response.RespondWithError(w, http.StatusBadRequest, "invalid body: "+err.Error(), ctx)
+		return false
+	}
+
+	annotation.From = body.From
+	annotation.To = body.To
+	annotation.Text = body.Text
+	annotation.Tags = body.Tags
+	if err := annotation.Validate(); err != nil {
+		response.RespondWithError(w, http.StatusBadRequest, err.Error(), ctx)
+		return false
+	}
+
+	for _, verse := range []int{annotation.From, annotation.To} {
+		_, err := repo.FindVerse(ctx, annotation.Book, annotation.Arabic, verse)
+		if err == ErrNotFound {
+			message := fmt.Sprintf("%s %d:%d does not exist", annotation.Book, annotation.Arabic, verse)
+			response.RespondWithError(w, http.StatusBadRequest, message, ctx)
+			return false
+		}
+		if err != nil {
+			respondWithStoreError(w, err, ctx)
+			return false
+		}
+	}
+	return true
+}
+
+func parseAnnotationFilter(req *http.Request) (AnnotationFilter, error) {
+	query := req.URL.Query()
+	filter := AnnotationFilter{
+		Tag:    strings.ToLower(strings.TrimSpace(query.Get("tag"))),
+		Author: query.Get("author"),
+	}
+
+	if value := query.Get("verse"); value != "" {
+		verse, err := strconv.Atoi(value)
+		if err != nil || verse < 1 {
+			return filter, fmt.Errorf("verse %q is not a valid number", value)
+		}
+		filter.Verse = verse
+	}
+	return filter, nil
+}
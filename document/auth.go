@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	microclient "github.com/joerivrij/microbases/shared/client"
+	"github.com/joerivrij/microbases/shared/response"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	openlog "github.com/opentracing/opentracing-go/log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OauthUrl is where bearer tokens are checked, without it nothing can be written under an author
+var OauthUrl string
+
+var (
+	errNoToken = errors.New("a bearer token is required")
+	errNoOauth = errors.New("OAUTH_URL is not set, there is no oauth server to check tokens with")
+)
+
+// maxTokenAge bounds how long a checked token is trusted without asking the oauth server again
+const maxTokenAge = time.Minute
+
+type tokenInfo struct {
+	ClientID  string `json:"client_id"`
+	UserID    string `json:"user_id"`
+	Scope     string `json:"scope"`
+	ExpiresIn int64  `json:"expires_in"`
+}
+
+type cachedToken struct {
+	author  string
+	expires time.Time
+}
+
+var tokenCache = struct {
+	sync.Mutex
+	tokens map[string]cachedToken
+}{tokens: map[string]cachedToken{}}
+
+// requireAuthor answers with a 401 when the request does not carry a valid token and with a 501 when
+// no oauth server is configured, an author is never taken on the word of the request
+func requireAuthor(w http.ResponseWriter, req *http.Request, ctx context.Context) (string, bool) {
+	author, err := tokenAuthor(ctx, req)
+	if err == nil {
+		return author, true
+	}
+	if err == errNoOauth {
+		response.RespondWithError(w, http.StatusNotImplemented, "authors cannot be checked: "+err.Error(), ctx)
+		return "", false
+	}
+
+	statusErr, ok := err.(*microclient.StatusError)
+	if err == errNoToken || ok && statusErr.StatusCode == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="microbases"`)
+		response.RespondWithError(w, http.StatusUnauthorized, "a valid bearer token is required", ctx)
+		return "", false
+	}
+	response.RespondWithError(w, http.StatusServiceUnavailable, "tokens cannot be checked: "+err.Error(), ctx)
+	return "", false
+}
+
+// requestAuthor names who made a change for the revisions, anonymous when the request has no valid token
+// or there is no oauth server to check it with
+func requestAuthor(ctx context.Context, req *http.Request) string {
+	author, err := tokenAuthor(ctx, req)
+	if err != nil {
+		return "anonymous"
+	}
+	return author
+}
+
+// tokenAuthor returns who the bearer token of the request belongs to, the user when the token was handed
+// out to one and otherwise the client
+func tokenAuthor(ctx context.Context, req *http.Request) (string, error) {
+	if OauthUrl == "" {
+		return "", errNoOauth
+	}
+
+	header := req.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return "", errNoToken
+	}
+	token := strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+
+	tokenCache.Lock()
+	cached, ok := tokenCache.tokens[token]
+	tokenCache.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.author, nil
+	}
+
+	info, err := validateToken(ctx, token)
+	if err != nil {
+		return "", err
+	}
+
+	author := info.UserID
+	if author == "" {
+		author = info.ClientID
+	}
+
+	expires := time.Now().Add(maxTokenAge)
+	if tokenExpires := time.Now().Add(time.Duration(info.ExpiresIn) * time.Second); tokenExpires.Before(expires) {
+		expires = tokenExpires
+	}
+
+	tokenCache.Lock()
+	for key, cached := range tokenCache.tokens {
+		if time.Now().After(cached.expires) {
+			delete(tokenCache.tokens, key)
+		}
+	}
+	tokenCache.tokens[token] = cachedToken{author: author, expires: expires}
+	tokenCache.Unlock()
+
+	return author, nil
+}
+
+func validateToken(ctx context.Context, token string) (tokenInfo, error) {
+	span, _ := opentracing.StartSpanFromContext(ctx, "validateToken")
+	defer span.Finish()
+
+	var info tokenInfo
+	validateUrl := fmt.Sprintf("http://%s/validate", OauthUrl)
+	req, err := http.NewRequest("GET", validateUrl, nil)
+	if err != nil {
+		return info, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	ext.SpanKindRPCClient.Set(span)
+	ext.HTTPUrl.Set(span, validateUrl)
+	ext.HTTPMethod.Set(span, "GET")
+	span.Tracer().Inject(
+		span.Context(),
+		opentracing.HTTPHeaders,
+		opentracing.HTTPHeadersCarrier(req.Header),
+	)
+
+	body, err := microclient.BackendCall(req)
+	if err != nil {
+		span.LogFields(
+			openlog.String("event", "calling oauth server"),
+			openlog.Error(err),
+		)
+		return info, err
+	}
+
+	return info, json.Unmarshal(body, &info)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/joerivrij/microbases/shared/models"
+	"github.com/joerivrij/microbases/shared/response"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	openlog "github.com/opentracing/opentracing-go/log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+func verseRangeHandler(w http.ResponseWriter, req *http.Request) {
+	spanCtx, _ := opentracing.GlobalTracer().Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(req.Header))
+	span := opentracing.GlobalTracer().StartSpan("verseRangeHandler", ext.RPCServerOption(spanCtx))
+	defer span.Finish()
+
+	ctx := opentracing.ContextWithSpan(req.Context(), span)
+
+	span.LogFields(
+		openlog.String("method", req.Method),
+		openlog.String("path", req.URL.Path),
+		openlog.String("host", req.Host),
+	)
+
+	vars := mux.Vars(req)
+	book, arabic, err := parseCantoVars(vars)
+	if err != nil {
+		response.RespondWithError(w, http.StatusBadRequest, err.Error(), ctx)
+		return
+	}
+
+	from, _ := strconv.Atoi(vars["from"])
+	to, _ := strconv.Atoi(vars["to"])
+	if from < 1 || to < from {
+		response.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("verses %d-%d are not a valid range", from, to), ctx)
+		return
+	}
+
+	citation := models.Citation{Book: book, Canto: arabic, From: from, To: to}
+	respondWithPassage(w, req, citation, ctx)
+}
+
+func citationHandler(w http.ResponseWriter, req *http.Request) {
+	spanCtx, _ := opentracing.GlobalTracer().Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(req.Header))
+	span := opentracing.GlobalTracer().StartSpan("citationHandler", ext.RPCServerOption(spanCtx))
+	defer span.Finish()
+
+	ctx := opentracing.ContextWithSpan(req.Context(), span)
+
+	span.LogFields(
+		openlog.String("method", req.Method),
+		openlog.String("path", req.URL.Path),
+		openlog.String("host", req.Host),
+	)
+
+	citation, err := models.ParseCitation(req.URL.Query().Get("ref"))
+	if err != nil {
+		response.RespondWithError(w, http.StatusBadRequest, err.Error(), ctx)
+		return
+	}
+
+	respondWithPassage(w, req, citation, ctx)
+}
+
+func respondWithPassage(w http.ResponseWriter, req *http.Request, citation models.Citation, ctx context.Context) {
+	verses, err := repo.FindPassage(ctx, citation)
+	if err != nil {
+		respondWithStoreError(w, err, ctx)
+		return
+	}
+	if len(verses) == 0 {
+		response.RespondWithError(w, http.StatusNotFound, citation.String()+" not found", ctx)
+		return
+	}
+
+	response.RespondWithCacheableJson(w, req, verses, time.Time{}, ctx)
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/joerivrij/microbases/shared/models"
+	"github.com/joerivrij/microbases/shared/response"
+	"github.com/joerivrij/microbases/shared/tokenizer"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	openlog "github.com/opentracing/opentracing-go/log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultWindow = 5
+	maxWindow     = 20
+	// writes made outside of this service, like an import, show up in the concordance after this long
+	concordanceTTL = 10 * time.Minute
+)
+
+// Occurrence is a single use of a word with the words around it, a keyword-in-context line
+type Occurrence struct {
+	Book    string `json:"book"`
+	Canto   int    `json:"canto"`
+	Verse   int    `json:"verse"`
+	Left    string `json:"left"`
+	Keyword string `json:"keyword"`
+	Right   string `json:"right"`
+}
+
+type ConcordanceResult struct {
+	Word        string       `json:"word"`
+	Translation string       `json:"translation,omitempty"`
+	Prefix      bool         `json:"prefix"`
+	Window      int          `json:"window"`
+	Total       int          `json:"total"`
+	Occurrences []Occurrence `json:"occurrences"`
+}
+
+// concordance indexes every word of the commedia by where it is used, once for the original and once
+// for every translation. The words of a text are kept as one running text in reading order so a context
+// window can run over the end of a verse
+type concordance struct {
+	built time.Time
+	texts map[string]*wordIndex
+}
+
+type wordIndex struct {
+	tokens    []token
+	positions map[string][]int
+}
+
+type token struct {
+	Word  string
+	Book  string
+	Canto int
+	Verse int
+}
+
+// concordanceWords looks up a word with and without accents, stop words are kept so they can be looked up as well
+var concordanceWords = tokenizer.Options{FoldAccents: true}
+
+var concordanceCache struct {
+	sync.Mutex
+	current *concordance
+}
+
+func concordanceHandler(w http.ResponseWriter, req *http.Request) {
+	spanCtx, _ := opentracing.GlobalTracer().Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(req.Header))
+	span := opentracing.GlobalTracer().StartSpan("concordanceHandler", ext.RPCServerOption(spanCtx))
+	defer span.Finish()
+
+	ctx := opentracing.ContextWithSpan(req.Context(), span)
+
+	span.LogFields(
+		openlog.String("method", req.Method),
+		openlog.String("path", req.URL.Path),
+		openlog.String("host", req.Host),
+	)
+
+	query := req.URL.Query()
+	word, _ := concordanceWords.Apply(strings.TrimSpace(query.Get("word")))
+	if word == "" || strings.IndexFunc(word, tokenizer.IsSeparator) >= 0 {
+		response.RespondWithError(w, http.StatusBadRequest, "query parameter word has to be a single word", ctx)
+		return
+	}
+
+	source, err := parseTextSource(query)
+	if err != nil {
+		response.RespondWithError(w, http.StatusBadRequest, err.Error(), ctx)
+		return
+	}
+
+	window := defaultWindow
+	if value := query.Get("window"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 || parsed > maxWindow {
+			response.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("window has to be between 0 and %d", maxWindow), ctx)
+			return
+		}
+		window = parsed
+	}
+
+	// there is no lemmatizer, prefix=true is the way to find the forms of a lemma (stell finds stella and stelle)
+	prefix := query.Get("prefix") == "true"
+
+	index, err := loadConcordance(ctx)
+	if err != nil {
+		respondWithStoreError(w, err, ctx)
+		return
+	}
+
+	occurrences := []Occurrence{}
+	if text, ok := index.texts[source]; ok {
+		occurrences = text.lookup(word, prefix, window)
+	}
+	span.LogFields(
+		openlog.String("word", word),
+		openlog.Int("occurrences", len(occurrences)),
+	)
+
+	result := ConcordanceResult{
+		Word:        word,
+		Translation: source,
+		Prefix:      prefix,
+		Window:      window,
+		Total:       len(occurrences),
+		Occurrences: occurrences,
+	}
+	response.RespondWithCacheableJson(w, req, result, time.Time{}, ctx)
+}
+
+// loadConcordance returns the cached concordance and builds it when there is none yet or it is too old
+func loadConcordance(ctx context.Context) (*concordance, error) {
+	concordanceCache.Lock()
+	defer concordanceCache.Unlock()
+
+	if concordanceCache.current != nil && time.Since(concordanceCache.current.built) < concordanceTTL {
+		return concordanceCache.current, nil
+	}
+
+	index, err := buildConcordance(ctx)
+	if err != nil {
+		return nil, err
+	}
+	concordanceCache.current = index
+	return index, nil
+}
+
+// invalidateConcordance drops the cached concordance so the next lookup sees a write
+func invalidateConcordance() {
+	concordanceCache.Lock()
+	concordanceCache.current = nil
+	concordanceCache.Unlock()
+}
+
+func buildConcordance(ctx context.Context) (*concordance, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "buildConcordance")
+	defer span.Finish()
+
+	index := &concordance{
+		built: time.Now(),
+		texts: map[string]*wordIndex{},
+	}
+
+	for _, book := range models.Books {
+		canti, _, err := repo.FindAll(ctx, book.Name, ListOptions{Sort: []string{"arabic", "verse"}})
+		if err != nil {
+			span.LogFields(
+				openlog.String("mongoresult", "error getting canti"),
+				openlog.Error(err),
+			)
+			return nil, err
+		}
+
+		for _, canto := range canti {
+			index.text(original).add(canto, canto.TextItalian)
+			for id, text := range canto.Translations {
+				index.text(id).add(canto, text)
+			}
+		}
+	}
+
+	words := 0
+	for _, text := range index.texts {
+		words += len(text.tokens)
+	}
+	span.LogFields(
+		openlog.Int("words", words),
+	)
+	return index, nil
+}
+
+func (c *concordance) text(source string) *wordIndex {
+	text, ok := c.texts[source]
+	if !ok {
+		text = &wordIndex{positions: map[string][]int{}}
+		c.texts[source] = text
+	}
+	return text
+}
+
+func (i *wordIndex) add(canto models.Canto, text string) {
+	for _, word := range strings.FieldsFunc(text, tokenizer.IsSeparator) {
+		form, _ := concordanceWords.Apply(word)
+		i.positions[form] = append(i.positions[form], len(i.tokens))
+		i.tokens = append(i.tokens, token{Word: word, Book: canto.Book, Canto: canto.Arabic, Verse: canto.Verse})
+	}
+}
+
+// lookup returns every occurrence in reading order, the context never runs into another canto
+func (i *wordIndex) lookup(word string, prefix bool, window int) []Occurrence {
+	var positions []int
+	if prefix {
+		for form, found := range i.positions {
+			if strings.HasPrefix(form, word) {
+				positions = append(positions, found...)
+			}
+		}
+		sort.Ints(positions)
+	} else {
+		positions = i.positions[word]
+	}
+
+	occurrences := make([]Occurrence, 0, len(positions))
+	for _, position := range positions {
+		found := i.tokens[position]
+
+		start := position
+		for start > 0 && position-start < window && i.sameCanto(start-1, position) {
+			start--
+		}
+		end := position + 1
+		for end < len(i.tokens) && end-position-1 < window && i.sameCanto(end, position) {
+			end++
+		}
+
+		occurrences = append(occurrences, Occurrence{
+			Book:    found.Book,
+			Canto:   found.Canto,
+			Verse:   found.Verse,
+			Left:    i.join(start, position),
+			Keyword: found.Word,
+			Right:   i.join(position+1, end),
+		})
+	}
+	return occurrences
+}
+
+func (i *wordIndex) sameCanto(a int, b int) bool {
+	return i.tokens[a].Book == i.tokens[b].Book && i.tokens[a].Canto == i.tokens[b].Canto
+}
+
+func (i *wordIndex) join(from int, to int) string {
+	words := make([]string, 0, to-from)
+	for _, token := range i.tokens[from:to] {
+		words = append(words, token.Word)
+	}
+	return strings.Join(words, " ")
+}
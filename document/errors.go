@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/joerivrij/microbases/shared/response"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+	"net/http"
+)
+
+// respondWithStoreError maps an error coming back from the store to the status the client should see
+func respondWithStoreError(w http.ResponseWriter, err error, ctx context.Context) {
+	if mongoUnavailable(err) {
+		response.RespondWithError(w, http.StatusServiceUnavailable, "the document database is not available", ctx)
+		return
+	}
+	response.RespondWithError(w, http.StatusInternalServerError, err.Error(), ctx)
+}
+
+// requireMongo answers with a 501 for the features that only work when the canti are stored in mongo
+func requireMongo(w http.ResponseWriter, ctx context.Context) bool {
+	if db == nil {
+		response.RespondWithError(w, http.StatusNotImplemented, "this endpoint needs the mongo store", ctx)
+		return false
+	}
+	return true
+}
+
+// mongoUnavailable tells whether mongo could not be reached in time, the driver reconnects
+// on its own so the next request can succeed again
+func mongoUnavailable(err error) bool {
+	if db == nil {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var selectionErr topology.ServerSelectionError
+	if errors.As(err, &selectionErr) {
+		return true
+	}
+	return mongo.IsNetworkError(err) || mongo.IsTimeout(err)
+}
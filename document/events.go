@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/joerivrij/microbases/shared/models"
+	"github.com/opentracing/opentracing-go"
+	openlog "github.com/opentracing/opentracing-go/log"
+	"github.com/segmentio/kafka-go"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"strings"
+	"time"
+)
+
+// CHANGESTREAMS keeps the resume token of the last published change so a restart continues where it left off
+const CHANGESTREAMS = "changestreams"
+
+const retryDelay = 5 * time.Second
+
+// changeEvent is the part of a mongo change stream document the events are made of
+type changeEvent struct {
+	OperationType string `bson:"operationType"`
+	DocumentKey   struct {
+		ID primitive.ObjectID `bson:"_id"`
+	} `bson:"documentKey"`
+	FullDocument             *models.Canto `bson:"fullDocument"`
+	FullDocumentBeforeChange *models.Canto `bson:"fullDocumentBeforeChange"`
+	UpdateDescription        struct {
+		UpdatedFields bson.M   `bson:"updatedFields"`
+		RemovedFields []string `bson:"removedFields"`
+	} `bson:"updateDescription"`
+	WallTime time.Time `bson:"wallTime"`
+}
+
+func newEventWriter(brokers string, topic string) *kafka.Writer {
+	return kafka.NewWriter(kafka.WriterConfig{
+		Brokers:  strings.Split(brokers, ","),
+		Topic:    topic,
+		Balancer: &kafka.Hash{},
+	})
+}
+
+// watchCanti follows the change stream of the canti collection and publishes every change as an event.
+// Change streams need mongo to run as a replica set, when the stream breaks it is reopened from the last
+// published change so events are published at least once
+func watchCanti(ctx context.Context, writer *kafka.Writer) {
+	for ctx.Err() == nil {
+		err := watchChanges(ctx, writer)
+		if err != nil && ctx.Err() == nil {
+			println("change stream stopped: " + err.Error())
+			time.Sleep(retryDelay)
+		}
+	}
+}
+
+func watchChanges(ctx context.Context, writer *kafka.Writer) error {
+	streamOptions := options.ChangeStream().
+		SetFullDocument(options.UpdateLookup).
+		SetFullDocumentBeforeChange(options.WhenAvailable)
+	if token, err := loadResumeToken(ctx); err == nil && token != nil {
+		streamOptions.SetResumeAfter(token)
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"operationType": bson.M{"$in": []string{"insert", "update", "replace", "delete"}}}}},
+	}
+	stream, err := db.Collection(COLLECTION).Watch(ctx, pipeline, streamOptions)
+	if err != nil {
+		return err
+	}
+	defer stream.Close(context.Background())
+
+	for stream.Next(ctx) {
+		var change changeEvent
+		if err := stream.Decode(&change); err != nil {
+			return err
+		}
+
+		if event, ok := cantoEvent(change); ok {
+			if err := publishEvent(ctx, writer, event); err != nil {
+				return err
+			}
+		}
+
+		if err := saveResumeToken(ctx, stream.ResumeToken()); err != nil {
+			return err
+		}
+	}
+	return stream.Err()
+}
+
+// cantoEvent turns a change into the event downstream services get, changes that only touch the
+// modified timestamp are not worth an event
+func cantoEvent(change changeEvent) (models.CantoEvent, bool) {
+	event := models.CantoEvent{
+		ID:        change.DocumentKey.ID.Hex(),
+		Timestamp: change.WallTime,
+	}
+
+	verse := change.FullDocument
+	switch change.OperationType {
+	case "insert":
+		event.Type = models.CantoCreated
+		event.Changes = cantoValues(*change.FullDocument)
+	case "replace":
+		event.Type = models.CantoUpdated
+		event.Changes = cantoValues(*change.FullDocument)
+	case "update":
+		event.Type = models.CantoUpdated
+		event.Changes = map[string]interface{}{}
+		for field, value := range change.UpdateDescription.UpdatedFields {
+			if field != "modified" {
+				event.Changes[field] = value
+			}
+		}
+		event.Removed = change.UpdateDescription.RemovedFields
+		if len(event.Changes) == 0 && len(event.Removed) == 0 {
+			return event, false
+		}
+		// the verse can be gone by the time the update is looked up
+		if verse == nil {
+			verse = change.FullDocumentBeforeChange
+		}
+	case "delete":
+		event.Type = models.CantoDeleted
+		verse = change.FullDocumentBeforeChange
+	default:
+		return event, false
+	}
+
+	// without pre-images a deleted verse is only known by its id
+	if verse != nil {
+		event.Book = verse.Book
+		event.Arabic = verse.Arabic
+		event.Verse = verse.Verse
+	}
+	return event, true
+}
+
+func publishEvent(ctx context.Context, writer *kafka.Writer, event models.CantoEvent) error {
+	span := opentracing.GlobalTracer().StartSpan("publishCantoEvent")
+	defer span.Finish()
+
+	value, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	span.LogFields(
+		openlog.String("type", event.Type),
+		openlog.String("verse", event.Key()),
+	)
+
+	err = writer.WriteMessages(ctx, kafka.Message{Key: []byte(event.Key()), Value: value})
+	if err != nil {
+		span.LogFields(
+			openlog.String("kafkaresult", "error publishing event"),
+			openlog.Error(err),
+		)
+	}
+	return err
+}
+
+func loadResumeToken(ctx context.Context) (bson.Raw, error) {
+	queryCtx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	var stored struct {
+		Token bson.Raw `bson:"token"`
+	}
+	err := db.Collection(CHANGESTREAMS).FindOne(queryCtx, bson.M{"_id": COLLECTION}).Decode(&stored)
+	return stored.Token, err
+}
+
+func saveResumeToken(ctx context.Context, token bson.Raw) error {
+	queryCtx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	_, err := db.Collection(CHANGESTREAMS).UpdateOne(queryCtx,
+		bson.M{"_id": COLLECTION},
+		bson.M{"$set": bson.M{"token": token}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
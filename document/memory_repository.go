@@ -0,0 +1,371 @@
+package main
+
+import (
+	"context"
+	"github.com/joerivrij/microbases/shared/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type verseKey struct {
+	book   string
+	arabic int
+	verse  int
+}
+
+// MemoryRepository keeps the canti in a map, it is used to run the service without mongo
+type MemoryRepository struct {
+	mutex sync.RWMutex
+	canti map[verseKey]models.Canto
+}
+
+func NewMemoryRepository(canti ...models.Canto) *MemoryRepository {
+	m := &MemoryRepository{canti: map[verseKey]models.Canto{}}
+	for _, canto := range canti {
+		if canto.ID.IsZero() {
+			canto.ID = primitive.NewObjectID()
+		}
+		m.canti[keyOf(canto)] = canto
+	}
+	return m
+}
+
+func keyOf(canto models.Canto) verseKey {
+	return verseKey{book: canto.Book, arabic: canto.Arabic, verse: canto.Verse}
+}
+
+func (m *MemoryRepository) FindAll(ctx context.Context, book string, options ListOptions) ([]models.Canto, int, error) {
+	return m.find(options, func(canto models.Canto) bool {
+		return canto.Book == book
+	})
+}
+
+func (m *MemoryRepository) FindByCanto(ctx context.Context, book string, arabic int, options ListOptions) ([]models.Canto, int, error) {
+	return m.find(options, func(canto models.Canto) bool {
+		return canto.Book == book && canto.Arabic == arabic
+	})
+}
+
+func (m *MemoryRepository) FindVerse(ctx context.Context, book string, arabic int, verse int) (models.Canto, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	canto, ok := m.canti[verseKey{book, arabic, verse}]
+	if !ok {
+		return canto, ErrNotFound
+	}
+	return canto, nil
+}
+
+func (m *MemoryRepository) FindPassage(ctx context.Context, citation models.Citation) ([]models.Canto, error) {
+	verses, _, err := m.find(ListOptions{Sort: []string{"verse"}}, func(canto models.Canto) bool {
+		return canto.Book == citation.Book && canto.Arabic == citation.Canto &&
+			canto.Verse >= citation.From && canto.Verse <= citation.To
+	})
+	return verses, err
+}
+
+func (m *MemoryRepository) Insert(ctx context.Context, canto models.Canto) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.canti[keyOf(canto)]; ok {
+		return ErrDuplicate
+	}
+	if canto.ID.IsZero() {
+		canto.ID = primitive.NewObjectID()
+	}
+	canto.Modified = time.Now().UTC()
+	m.canti[keyOf(canto)] = canto
+	return nil
+}
+
+func (m *MemoryRepository) Update(ctx context.Context, book string, arabic int, verse int, values map[string]interface{}) (models.Canto, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	key := verseKey{book, arabic, verse}
+	before, ok := m.canti[key]
+	if !ok {
+		return before, ErrNotFound
+	}
+
+	after := applyValues(before, values)
+	after.Modified = time.Now().UTC()
+	m.canti[key] = after
+	return before, nil
+}
+
+func (m *MemoryRepository) Save(ctx context.Context, canto models.Canto) (models.Canto, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	key := keyOf(canto)
+	before, ok := m.canti[key]
+	if ok {
+		canto.ID = before.ID
+	} else if canto.ID.IsZero() {
+		canto.ID = primitive.NewObjectID()
+	}
+
+	canto.Modified = time.Now().UTC()
+	m.canti[key] = canto
+	return before, nil
+}
+
+func (m *MemoryRepository) Delete(ctx context.Context, book string, arabic int, verse int) (models.Canto, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	key := verseKey{book, arabic, verse}
+	before, ok := m.canti[key]
+	if !ok {
+		return before, ErrNotFound
+	}
+
+	delete(m.canti, key)
+	return before, nil
+}
+
+// find filters, sorts and pages the canti the same way the mongo queries do, a limit of 0 means no limit
+func (m *MemoryRepository) find(options ListOptions, matches func(models.Canto) bool) ([]models.Canto, int, error) {
+	m.mutex.RLock()
+	var found []models.Canto
+	for _, canto := range m.canti {
+		if matches(canto) {
+			found = append(found, canto)
+		}
+	}
+	m.mutex.RUnlock()
+
+	sort.SliceStable(found, func(i, j int) bool {
+		for _, field := range options.Sort {
+			descending := strings.HasPrefix(field, "-")
+			order := compareField(found[i], found[j], strings.TrimPrefix(field, "-"))
+			if order != 0 {
+				return (order < 0) != descending
+			}
+		}
+		return false
+	})
+
+	total := len(found)
+	if options.Offset >= total {
+		return nil, total, nil
+	}
+	found = found[options.Offset:]
+	if options.Limit > 0 && options.Limit < len(found) {
+		found = found[:options.Limit]
+	}
+	return found, total, nil
+}
+
+// compareField compares two canti on a stored field name like sort options use them
+func compareField(a models.Canto, b models.Canto, field string) int {
+	switch field {
+	case "arabic":
+		return a.Arabic - b.Arabic
+	case "verse":
+		return a.Verse - b.Verse
+	case "words":
+		return a.Words - b.Words
+	case "_id":
+		return strings.Compare(a.ID.Hex(), b.ID.Hex())
+	case "book":
+		return strings.Compare(a.Book, b.Book)
+	case "title":
+		return strings.Compare(a.Title, b.Title)
+	case "roman":
+		return strings.Compare(a.Roman, b.Roman)
+	case "textItalian":
+		return strings.Compare(a.TextItalian, b.TextItalian)
+	}
+	if id := strings.TrimPrefix(field, "translations."); id != field {
+		return strings.Compare(a.Translations[id], b.Translations[id])
+	}
+	return 0
+}
+
+type MemoryTranslationRepository struct {
+	mutex        sync.RWMutex
+	translations map[string]models.Translation
+}
+
+func NewMemoryTranslationRepository(translations ...models.Translation) *MemoryTranslationRepository {
+	m := &MemoryTranslationRepository{translations: map[string]models.Translation{}}
+	for _, translation := range translations {
+		m.translations[translation.ID] = translation
+	}
+	return m
+}
+
+func (m *MemoryTranslationRepository) FindAll(ctx context.Context) ([]models.Translation, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	found := make([]models.Translation, 0, len(m.translations))
+	for _, translation := range m.translations {
+		found = append(found, translation)
+	}
+	sort.Slice(found, func(i, j int) bool {
+		return found[i].ID < found[j].ID
+	})
+	return found, nil
+}
+
+func (m *MemoryTranslationRepository) Find(ctx context.Context, id string) (models.Translation, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	translation, ok := m.translations[id]
+	if !ok {
+		return translation, ErrNotFound
+	}
+	return translation, nil
+}
+
+func (m *MemoryTranslationRepository) Save(ctx context.Context, translation models.Translation) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.translations[translation.ID] = translation
+	return nil
+}
+
+type MemoryAnnotationRepository struct {
+	mutex       sync.RWMutex
+	annotations map[primitive.ObjectID]models.Annotation
+}
+
+func NewMemoryAnnotationRepository() *MemoryAnnotationRepository {
+	return &MemoryAnnotationRepository{annotations: map[primitive.ObjectID]models.Annotation{}}
+}
+
+func (m *MemoryAnnotationRepository) FindByCanto(ctx context.Context, book string, arabic int, filter AnnotationFilter) ([]models.Annotation, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	found := []models.Annotation{}
+	for _, annotation := range m.annotations {
+		if annotation.Book != book || annotation.Arabic != arabic {
+			continue
+		}
+		if filter.Verse > 0 && !annotation.Covers(filter.Verse) {
+			continue
+		}
+		if filter.Tag != "" && !containsString(annotation.Tags, filter.Tag) {
+			continue
+		}
+		if filter.Author != "" && annotation.Author != filter.Author {
+			continue
+		}
+		found = append(found, annotation)
+	}
+
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].From != found[j].From {
+			return found[i].From < found[j].From
+		}
+		return found[i].Created.Before(found[j].Created)
+	})
+	return found, nil
+}
+
+func (m *MemoryAnnotationRepository) Find(ctx context.Context, id primitive.ObjectID) (models.Annotation, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	annotation, ok := m.annotations[id]
+	if !ok {
+		return annotation, ErrNotFound
+	}
+	return annotation, nil
+}
+
+func (m *MemoryAnnotationRepository) Insert(ctx context.Context, annotation models.Annotation) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.annotations[annotation.ID]; ok {
+		return ErrDuplicate
+	}
+	m.annotations[annotation.ID] = annotation
+	return nil
+}
+
+func (m *MemoryAnnotationRepository) Replace(ctx context.Context, annotation models.Annotation) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.annotations[annotation.ID]; !ok {
+		return ErrNotFound
+	}
+	m.annotations[annotation.ID] = annotation
+	return nil
+}
+
+func (m *MemoryAnnotationRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.annotations[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.annotations, id)
+	return nil
+}
+
+type MemoryRevisionRepository struct {
+	mutex     sync.RWMutex
+	revisions []Revision
+}
+
+func NewMemoryRevisionRepository() *MemoryRevisionRepository {
+	return &MemoryRevisionRepository{}
+}
+
+func (m *MemoryRevisionRepository) FindByVerse(ctx context.Context, book string, arabic int, verse int) ([]Revision, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	found := []Revision{}
+	for i := len(m.revisions) - 1; i >= 0; i-- {
+		revision := m.revisions[i]
+		if revision.Book == book && revision.Arabic == arabic && revision.Verse == verse {
+			found = append(found, revision)
+		}
+	}
+	return found, nil
+}
+
+func (m *MemoryRevisionRepository) Find(ctx context.Context, book string, arabic int, verse int, id primitive.ObjectID) (Revision, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	for _, revision := range m.revisions {
+		if revision.ID == id && revision.Book == book && revision.Arabic == arabic && revision.Verse == verse {
+			return revision, nil
+		}
+	}
+	return Revision{}, ErrNotFound
+}
+
+func (m *MemoryRevisionRepository) Insert(ctx context.Context, revision Revision) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.revisions = append(m.revisions, revision)
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
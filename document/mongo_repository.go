@@ -0,0 +1,566 @@
+package main
+
+import (
+	"context"
+	"github.com/joerivrij/microbases/shared/models"
+	"github.com/opentracing/opentracing-go"
+	openlog "github.com/opentracing/opentracing-go/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"strings"
+	"time"
+)
+
+type MongoRepository struct {
+	db *mongo.Database
+}
+
+func NewMongoRepository(db *mongo.Database) *MongoRepository {
+	return &MongoRepository{db: db}
+}
+
+func (m *MongoRepository) FindAll(ctx context.Context, book string, listOptions ListOptions) ([]models.Canto, int, error) {
+	return m.findAllWithQuery(ctx, bson.M{"book": book}, listOptions)
+}
+
+func (m *MongoRepository) FindByCanto(ctx context.Context, book string, arabic int, listOptions ListOptions) ([]models.Canto, int, error) {
+	return m.findAllWithQuery(ctx, bson.M{"book": book, "arabic": arabic}, listOptions)
+}
+
+func (m *MongoRepository) findAllWithQuery(ctx context.Context, query bson.M, listOptions ListOptions) ([]models.Canto, int, error) {
+	span, _ := opentracing.StartSpanFromContext(ctx, "findWithQuery")
+	defer span.Finish()
+	var canti []models.Canto
+
+	queryCtx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	total, err := m.db.Collection(COLLECTION).CountDocuments(queryCtx, query)
+	if err != nil {
+		span.LogFields(
+			openlog.String("mongoresult", "error counting canti"),
+		)
+		return canti, 0, err
+	}
+
+	findOptions := options.Find().
+		SetProjection(selector(listOptions)).
+		SetSort(sortOrder(listOptions.Sort)).
+		SetSkip(int64(listOptions.Offset))
+	if listOptions.Limit > 0 {
+		findOptions.SetLimit(int64(listOptions.Limit))
+	}
+
+	cursor, err := m.db.Collection(COLLECTION).Find(queryCtx, query, findOptions)
+	if err == nil {
+		err = cursor.All(queryCtx, &canti)
+	}
+	if err != nil {
+		span.LogFields(
+			openlog.String("mongoresult", "error getting canti"),
+		)
+	}
+
+	span.LogFields(
+		openlog.Int("mongoresult", len(canti)),
+		openlog.Int64("total", total),
+	)
+
+	return canti, int(total), err
+}
+
+func (m *MongoRepository) FindVerse(ctx context.Context, book string, arabic int, verse int) (models.Canto, error) {
+	span, _ := opentracing.StartSpanFromContext(ctx, "findOneWithQuery")
+	defer span.Finish()
+	var canto models.Canto
+
+	queryCtx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	query := bson.M{"book": book, "arabic": arabic, "verse": verse}
+	err := m.db.Collection(COLLECTION).FindOne(queryCtx, query).Decode(&canto)
+	if err != nil {
+		span.LogFields(
+			openlog.String("mongoresult", "error getting canto"),
+			openlog.Error(err),
+		)
+		return canto, mongoError(err)
+	}
+
+	span.LogFields(
+		openlog.String("mongoresult", canto.ID.Hex()),
+	)
+
+	return canto, nil
+}
+
+// FindPassage returns the verses of a citation in reading order
+func (m *MongoRepository) FindPassage(ctx context.Context, citation models.Citation) ([]models.Canto, error) {
+	span, _ := opentracing.StartSpanFromContext(ctx, "findPassage")
+	defer span.Finish()
+
+	span.LogFields(
+		openlog.String("citation", citation.String()),
+	)
+
+	queryCtx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	var verses []models.Canto
+	query := bson.M{
+		"book":   citation.Book,
+		"arabic": citation.Canto,
+		"verse":  bson.M{"$gte": citation.From, "$lte": citation.To},
+	}
+
+	findOptions := options.Find().SetSort(bson.D{{Key: "verse", Value: 1}})
+	cursor, err := m.db.Collection(COLLECTION).Find(queryCtx, query, findOptions)
+	if err == nil {
+		err = cursor.All(queryCtx, &verses)
+	}
+	if err != nil {
+		span.LogFields(
+			openlog.String("mongoresult", "error getting passage"),
+			openlog.Error(err),
+		)
+		return nil, err
+	}
+
+	span.LogFields(
+		openlog.Int("mongoresult", len(verses)),
+	)
+
+	return verses, nil
+}
+
+func (m *MongoRepository) Insert(ctx context.Context, canto models.Canto) error {
+	span, _ := opentracing.StartSpanFromContext(ctx, "insertCanto")
+	defer span.Finish()
+
+	queryCtx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	canto.Modified = time.Now().UTC()
+	_, err := m.db.Collection(COLLECTION).InsertOne(queryCtx, &canto)
+	if err != nil {
+		span.LogFields(
+			openlog.String("mongoresult", "error inserting canto"),
+			openlog.Error(err),
+		)
+	}
+	return mongoError(err)
+}
+
+func (m *MongoRepository) Update(ctx context.Context, book string, arabic int, verse int, values map[string]interface{}) (models.Canto, error) {
+	span, _ := opentracing.StartSpanFromContext(ctx, "updateCanto")
+	defer span.Finish()
+
+	queryCtx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	// the values are set in a pipeline, literal keeps them from being read as expressions
+	set := bson.M{"modified": time.Now().UTC()}
+	var unset bson.A
+	translationsWritten := false
+	for field, value := range values {
+		if field == "translations" || strings.HasPrefix(field, "translations.") {
+			translationsWritten = true
+		}
+		if value == nil {
+			unset = append(unset, field)
+			continue
+		}
+		set[field] = bson.M{"$literal": value}
+	}
+
+	update := mongo.Pipeline{}
+	if translationsWritten {
+		// a canto the migrate command has not moved yet would get its english text back from textEnglish
+		// on the next read, so it becomes the default translation before the translations are written
+		legacy := "translations." + models.DefaultTranslation
+		update = append(update, bson.D{{Key: "$set", Value: bson.M{
+			legacy: bson.M{"$ifNull": bson.A{"$" + legacy, "$textEnglish"}},
+		}}})
+		unset = append(unset, "textEnglish")
+	}
+	update = append(update, bson.D{{Key: "$set", Value: set}})
+	if len(unset) > 0 {
+		update = append(update, bson.D{{Key: "$unset", Value: unset}})
+	}
+
+	var before models.Canto
+	query := bson.M{"book": book, "arabic": arabic, "verse": verse}
+	updateOptions := options.FindOneAndUpdate().SetReturnDocument(options.Before)
+	err := m.db.Collection(COLLECTION).FindOneAndUpdate(queryCtx, query, update, updateOptions).Decode(&before)
+	if err != nil {
+		span.LogFields(
+			openlog.String("mongoresult", "error updating canto"),
+			openlog.Error(err),
+		)
+	}
+	return before, mongoError(err)
+}
+
+func (m *MongoRepository) Save(ctx context.Context, canto models.Canto) (models.Canto, error) {
+	span, _ := opentracing.StartSpanFromContext(ctx, "saveCanto")
+	defer span.Finish()
+
+	queryCtx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	set := bson.M(cantoValues(canto))
+	set["modified"] = time.Now().UTC()
+
+	id := canto.ID
+	if id.IsZero() {
+		id = primitive.NewObjectID()
+	}
+
+	var before models.Canto
+	query := bson.M{"book": canto.Book, "arabic": canto.Arabic, "verse": canto.Verse}
+	// the translations are written as a whole, a textEnglish left from before the migration would come back
+	// as the default translation on the next read
+	update := bson.M{
+		"$set":         set,
+		"$unset":       bson.M{"textEnglish": ""},
+		"$setOnInsert": bson.M{"_id": id},
+	}
+	updateOptions := options.FindOneAndUpdate().SetReturnDocument(options.Before).SetUpsert(true)
+
+	// when the verse gets inserted there is no old document and before stays empty
+	err := m.db.Collection(COLLECTION).FindOneAndUpdate(queryCtx, query, update, updateOptions).Decode(&before)
+	if err == mongo.ErrNoDocuments {
+		return before, nil
+	}
+	if err != nil {
+		span.LogFields(
+			openlog.String("mongoresult", "error saving canto"),
+			openlog.Error(err),
+		)
+	}
+	return before, mongoError(err)
+}
+
+func (m *MongoRepository) Delete(ctx context.Context, book string, arabic int, verse int) (models.Canto, error) {
+	span, _ := opentracing.StartSpanFromContext(ctx, "removeCanto")
+	defer span.Finish()
+
+	queryCtx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	var before models.Canto
+	query := bson.M{"book": book, "arabic": arabic, "verse": verse}
+	err := m.db.Collection(COLLECTION).FindOneAndDelete(queryCtx, query).Decode(&before)
+	if err != nil {
+		span.LogFields(
+			openlog.String("mongoresult", "error removing canto"),
+			openlog.Error(err),
+		)
+	}
+	return before, mongoError(err)
+}
+
+// selector returns the mongo projection for the requested fields, nil means everything
+func selector(listOptions ListOptions) interface{} {
+	if len(listOptions.Fields) == 0 {
+		return nil
+	}
+
+	// modified is always needed for the Last-Modified header
+	selector := bson.M{"modified": 1}
+	for _, field := range listOptions.Fields {
+		selector[cantoFields[field]] = 1
+		// canti the migrate command has not moved yet still hold the english text in textEnglish
+		if field == "textEnglish" {
+			selector["textEnglish"] = 1
+		}
+	}
+	return selector
+}
+
+// sortOrder turns the sort fields of the list options, prefixed with - when descending, into a mongo sort
+func sortOrder(fields []string) bson.D {
+	order := bson.D{}
+	for _, field := range fields {
+		if strings.HasPrefix(field, "-") {
+			order = append(order, bson.E{Key: strings.TrimPrefix(field, "-"), Value: -1})
+			continue
+		}
+		order = append(order, bson.E{Key: field, Value: 1})
+	}
+	return order
+}
+
+// mongoError translates the driver errors handlers care about to the ones of the repository
+func mongoError(err error) error {
+	if err == mongo.ErrNoDocuments {
+		return ErrNotFound
+	}
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+type MongoTranslationRepository struct {
+	db *mongo.Database
+}
+
+func NewMongoTranslationRepository(db *mongo.Database) *MongoTranslationRepository {
+	return &MongoTranslationRepository{db: db}
+}
+
+func (m *MongoTranslationRepository) FindAll(ctx context.Context) ([]models.Translation, error) {
+	span, _ := opentracing.StartSpanFromContext(ctx, "findTranslations")
+	defer span.Finish()
+
+	queryCtx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	found := []models.Translation{}
+	findOptions := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := m.db.Collection(TRANSLATIONS).Find(queryCtx, bson.M{}, findOptions)
+	if err == nil {
+		err = cursor.All(queryCtx, &found)
+	}
+	if err != nil {
+		span.LogFields(
+			openlog.String("mongoresult", "error getting translations"),
+			openlog.Error(err),
+		)
+	}
+	return found, err
+}
+
+func (m *MongoTranslationRepository) Find(ctx context.Context, id string) (models.Translation, error) {
+	span, _ := opentracing.StartSpanFromContext(ctx, "findTranslation")
+	defer span.Finish()
+
+	queryCtx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	var translation models.Translation
+	err := m.db.Collection(TRANSLATIONS).FindOne(queryCtx, bson.M{"_id": id}).Decode(&translation)
+	if err != nil {
+		span.LogFields(
+			openlog.String("mongoresult", "error getting translation"),
+			openlog.Error(err),
+		)
+	}
+	return translation, mongoError(err)
+}
+
+func (m *MongoTranslationRepository) Save(ctx context.Context, translation models.Translation) error {
+	span, _ := opentracing.StartSpanFromContext(ctx, "saveTranslation")
+	defer span.Finish()
+
+	queryCtx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	_, err := m.db.Collection(TRANSLATIONS).ReplaceOne(queryCtx, bson.M{"_id": translation.ID}, translation, options.Replace().SetUpsert(true))
+	if err != nil {
+		span.LogFields(
+			openlog.String("mongoresult", "error saving translation"),
+			openlog.Error(err),
+		)
+	}
+	return err
+}
+
+type MongoAnnotationRepository struct {
+	db *mongo.Database
+}
+
+func NewMongoAnnotationRepository(db *mongo.Database) *MongoAnnotationRepository {
+	return &MongoAnnotationRepository{db: db}
+}
+
+func (m *MongoAnnotationRepository) FindByCanto(ctx context.Context, book string, arabic int, filter AnnotationFilter) ([]models.Annotation, error) {
+	span, _ := opentracing.StartSpanFromContext(ctx, "findAnnotations")
+	defer span.Finish()
+
+	queryCtx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	query := bson.M{"book": book, "arabic": arabic}
+	if filter.Verse > 0 {
+		query["from"] = bson.M{"$lte": filter.Verse}
+		query["to"] = bson.M{"$gte": filter.Verse}
+	}
+	if filter.Tag != "" {
+		query["tags"] = filter.Tag
+	}
+	if filter.Author != "" {
+		query["author"] = filter.Author
+	}
+
+	found := []models.Annotation{}
+	findOptions := options.Find().SetSort(bson.D{{Key: "from", Value: 1}, {Key: "created", Value: 1}})
+	cursor, err := m.db.Collection(ANNOTATIONS).Find(queryCtx, query, findOptions)
+	if err == nil {
+		err = cursor.All(queryCtx, &found)
+	}
+	if err != nil {
+		span.LogFields(
+			openlog.String("mongoresult", "error getting annotations"),
+			openlog.Error(err),
+		)
+		return nil, err
+	}
+
+	span.LogFields(
+		openlog.Int("mongoresult", len(found)),
+	)
+	return found, nil
+}
+
+func (m *MongoAnnotationRepository) Find(ctx context.Context, id primitive.ObjectID) (models.Annotation, error) {
+	span, _ := opentracing.StartSpanFromContext(ctx, "findAnnotation")
+	defer span.Finish()
+
+	queryCtx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	var annotation models.Annotation
+	err := m.db.Collection(ANNOTATIONS).FindOne(queryCtx, bson.M{"_id": id}).Decode(&annotation)
+	if err != nil {
+		span.LogFields(
+			openlog.String("mongoresult", "error getting annotation"),
+			openlog.Error(err),
+		)
+	}
+	return annotation, mongoError(err)
+}
+
+func (m *MongoAnnotationRepository) Insert(ctx context.Context, annotation models.Annotation) error {
+	span, _ := opentracing.StartSpanFromContext(ctx, "insertAnnotation")
+	defer span.Finish()
+
+	queryCtx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	_, err := m.db.Collection(ANNOTATIONS).InsertOne(queryCtx, annotation)
+	if err != nil {
+		span.LogFields(
+			openlog.String("mongoresult", "error inserting annotation"),
+			openlog.Error(err),
+		)
+	}
+	return mongoError(err)
+}
+
+func (m *MongoAnnotationRepository) Replace(ctx context.Context, annotation models.Annotation) error {
+	span, _ := opentracing.StartSpanFromContext(ctx, "replaceAnnotation")
+	defer span.Finish()
+
+	queryCtx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	result, err := m.db.Collection(ANNOTATIONS).ReplaceOne(queryCtx, bson.M{"_id": annotation.ID}, annotation)
+	if err != nil {
+		span.LogFields(
+			openlog.String("mongoresult", "error replacing annotation"),
+			openlog.Error(err),
+		)
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (m *MongoAnnotationRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	span, _ := opentracing.StartSpanFromContext(ctx, "removeAnnotation")
+	defer span.Finish()
+
+	queryCtx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	result, err := m.db.Collection(ANNOTATIONS).DeleteOne(queryCtx, bson.M{"_id": id})
+	if err != nil {
+		span.LogFields(
+			openlog.String("mongoresult", "error removing annotation"),
+			openlog.Error(err),
+		)
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type MongoRevisionRepository struct {
+	db *mongo.Database
+}
+
+func NewMongoRevisionRepository(db *mongo.Database) *MongoRevisionRepository {
+	return &MongoRevisionRepository{db: db}
+}
+
+func (m *MongoRevisionRepository) FindByVerse(ctx context.Context, book string, arabic int, verse int) ([]Revision, error) {
+	span, _ := opentracing.StartSpanFromContext(ctx, "findRevisions")
+	defer span.Finish()
+
+	queryCtx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	found := []Revision{}
+	query := bson.M{"book": book, "arabic": arabic, "verse": verse}
+	findOptions := options.Find().SetSort(bson.D{{Key: "timestamp", Value: -1}})
+	cursor, err := m.db.Collection(REVISIONS).Find(queryCtx, query, findOptions)
+	if err == nil {
+		err = cursor.All(queryCtx, &found)
+	}
+	if err != nil {
+		span.LogFields(
+			openlog.String("mongoresult", "error getting revisions"),
+			openlog.Error(err),
+		)
+		return nil, err
+	}
+
+	span.LogFields(
+		openlog.Int("mongoresult", len(found)),
+	)
+	return found, nil
+}
+
+func (m *MongoRevisionRepository) Find(ctx context.Context, book string, arabic int, verse int, id primitive.ObjectID) (Revision, error) {
+	span, _ := opentracing.StartSpanFromContext(ctx, "findRevision")
+	defer span.Finish()
+
+	queryCtx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	var revision Revision
+	query := bson.M{"_id": id, "book": book, "arabic": arabic, "verse": verse}
+	err := m.db.Collection(REVISIONS).FindOne(queryCtx, query).Decode(&revision)
+	if err != nil {
+		span.LogFields(
+			openlog.String("mongoresult", "error getting revision"),
+			openlog.Error(err),
+		)
+	}
+	return revision, mongoError(err)
+}
+
+func (m *MongoRevisionRepository) Insert(ctx context.Context, revision Revision) error {
+	span, _ := opentracing.StartSpanFromContext(ctx, "insertRevision")
+	defer span.Finish()
+
+	queryCtx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	_, err := m.db.Collection(REVISIONS).InsertOne(queryCtx, &revision)
+	if err != nil {
+		span.LogFields(
+			openlog.String("mongoresult", "error inserting revision"),
+			openlog.Error(err),
+		)
+	}
+	return mongoError(err)
+}
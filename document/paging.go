@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/joerivrij/microbases/shared/models"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultListLimit = 200
+	maxListLimit     = 1000
+)
+
+// maps the json names clients use to the fields stored in mongo
+var cantoFields = map[string]string{
+	"id":           "_id",
+	"book":         "book",
+	"title":        "title",
+	"roman":        "roman",
+	"arabic":       "arabic",
+	"verse":        "verse",
+	"words":        "words",
+	"textItalian":  "textItalian",
+	"translations": "translations",
+	// textEnglish is still sent for the default translation, see models.Canto.MarshalJSON
+	"textEnglish": "translations." + models.DefaultTranslation,
+}
+
+// ListOptions holds the paging, sorting and projection asked for on a list endpoint
+type ListOptions struct {
+	Limit  int
+	Offset int
+	Sort   []string
+	Fields []string
+}
+
+func parseListOptions(values url.Values) (ListOptions, error) {
+	options := ListOptions{
+		Limit: defaultListLimit,
+		Sort:  []string{"arabic", "verse"},
+	}
+
+	if value := values.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxListLimit {
+			return options, fmt.Errorf("limit has to be between 1 and %d", maxListLimit)
+		}
+		options.Limit = limit
+	}
+
+	if value := values.Get("offset"); value != "" {
+		offset, err := strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return options, fmt.Errorf("offset %q is not a valid number", value)
+		}
+		options.Offset = offset
+	}
+
+	if value := values.Get("sort"); value != "" {
+		options.Sort = nil
+		for _, field := range strings.Split(value, ",") {
+			name := strings.TrimPrefix(field, "-")
+			stored, ok := cantoFields[name]
+			if !ok {
+				return options, fmt.Errorf("cannot sort on %q", name)
+			}
+			if strings.HasPrefix(field, "-") {
+				stored = "-" + stored
+			}
+			options.Sort = append(options.Sort, stored)
+		}
+	}
+
+	if value := values.Get("fields"); value != "" {
+		for _, field := range strings.Split(value, ",") {
+			if _, ok := cantoFields[field]; !ok {
+				return options, fmt.Errorf("unknown field %q", field)
+			}
+			options.Fields = append(options.Fields, field)
+		}
+	}
+
+	return options, nil
+}
+
+// project strips the canti down to the requested fields so the response only carries those
+func (o ListOptions) project(canti []models.Canto) interface{} {
+	if len(o.Fields) == 0 {
+		return canti
+	}
+
+	projected := make([]map[string]interface{}, 0, len(canti))
+	for _, canto := range canti {
+		var full map[string]interface{}
+		body, _ := json.Marshal(canto)
+		json.Unmarshal(body, &full)
+
+		fields := make(map[string]interface{}, len(o.Fields))
+		for _, field := range o.Fields {
+			fields[field] = full[field]
+		}
+		projected = append(projected, fields)
+	}
+	return projected
+}
+
+// setPagingHeaders writes the total count and the links to the neighbouring pages
+func setPagingHeaders(w http.ResponseWriter, req *http.Request, options ListOptions, total int) {
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+
+	link := func(offset int, rel string) string {
+		u := *req.URL
+		query := u.Query()
+		query.Set("offset", strconv.Itoa(offset))
+		query.Set("limit", strconv.Itoa(options.Limit))
+		u.RawQuery = query.Encode()
+		return fmt.Sprintf("<%s>; rel=\"%s\"", u.RequestURI(), rel)
+	}
+
+	last := 0
+	if total > 0 {
+		last = (total - 1) / options.Limit * options.Limit
+	}
+
+	links := []string{link(0, "first")}
+	if options.Offset > 0 {
+		prev := options.Offset - options.Limit
+		if prev < 0 {
+			prev = 0
+		}
+		links = append(links, link(prev, "prev"))
+	}
+	if options.Offset+options.Limit < total {
+		links = append(links, link(options.Offset+options.Limit, "next"))
+	}
+	links = append(links, link(last, "last"))
+
+	w.Header().Set("Link", strings.Join(links, ", "))
+}
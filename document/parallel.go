@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/joerivrij/microbases/shared/models"
+	"github.com/joerivrij/microbases/shared/response"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	openlog "github.com/opentracing/opentracing-go/log"
+	"net/http"
+	"time"
+)
+
+func parallelCantoHandler(w http.ResponseWriter, req *http.Request) {
+	spanCtx, _ := opentracing.GlobalTracer().Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(req.Header))
+	span := opentracing.GlobalTracer().StartSpan("parallelCantoHandler", ext.RPCServerOption(spanCtx))
+	defer span.Finish()
+
+	ctx := opentracing.ContextWithSpan(req.Context(), span)
+
+	span.LogFields(
+		openlog.String("method", req.Method),
+		openlog.String("path", req.URL.Path),
+		openlog.String("host", req.Host),
+	)
+
+	book, arabic, err := parseCantoVars(mux.Vars(req))
+	if err != nil {
+		response.RespondWithError(w, http.StatusBadRequest, err.Error(), ctx)
+		return
+	}
+
+	id := req.URL.Query().Get("translation")
+	if id == "" {
+		id = models.DefaultTranslation
+	}
+	translation, err := translations.Find(ctx, id)
+	if err == ErrNotFound {
+		response.RespondWithError(w, http.StatusNotFound, fmt.Sprintf("translation %q not found", id), ctx)
+		return
+	}
+	if err != nil {
+		respondWithStoreError(w, err, ctx)
+		return
+	}
+
+	verses, _, err := repo.FindByCanto(ctx, book, arabic, ListOptions{Sort: []string{"verse"}})
+	if err != nil {
+		respondWithStoreError(w, err, ctx)
+		return
+	}
+	if len(verses) == 0 {
+		response.RespondWithError(w, http.StatusNotFound, fmt.Sprintf("%s canto %d not found", book, arabic), ctx)
+		return
+	}
+
+	response.RespondWithCacheableJson(w, req, models.AlignCanto(verses, translation), time.Time{}, ctx)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"github.com/joerivrij/microbases/shared/models"
+	"strconv"
+)
+
+// parseBook returns the book from the path the way it is stored, so inferno and hell both become Inferno
+func parseBook(vars map[string]string) (string, error) {
+	book, ok := models.LookupBook(vars["book"])
+	if !ok {
+		return "", fmt.Errorf("book %q is not a valid book", vars["book"])
+	}
+	return book.Name, nil
+}
+
+func parseCantoVars(vars map[string]string) (string, int, error) {
+	book, ok := models.LookupBook(vars["book"])
+	if !ok {
+		return "", 0, fmt.Errorf("book %q is not a valid book", vars["book"])
+	}
+
+	arabic, err := strconv.Atoi(vars["canto"])
+	if err != nil || arabic < 1 || arabic > book.Cantos {
+		return book.Name, 0, fmt.Errorf("canto %q is not a valid number for %s", vars["canto"], book.Name)
+	}
+
+	return book.Name, arabic, nil
+}
+
+func parseVerseVars(vars map[string]string) (string, int, int, error) {
+	book, arabic, err := parseCantoVars(vars)
+	if err != nil {
+		return book, arabic, 0, err
+	}
+
+	verse, err := strconv.Atoi(vars["verse"])
+	if err != nil || verse < 1 {
+		return book, arabic, 0, fmt.Errorf("verse %q is not a valid number", vars["verse"])
+	}
+
+	return book, arabic, verse, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/joerivrij/microbases/shared/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrNotFound  = errors.New("verse not found")
+	ErrDuplicate = errors.New("verse already exists")
+)
+
+// CantoRepository stores the verses of the commedia, the handlers only reach the canti through it.
+// Every write sets the modified time of the verse and returns the verse as it was before the write
+type CantoRepository interface {
+	FindAll(ctx context.Context, book string, options ListOptions) ([]models.Canto, int, error)
+	FindByCanto(ctx context.Context, book string, arabic int, options ListOptions) ([]models.Canto, int, error)
+	FindVerse(ctx context.Context, book string, arabic int, verse int) (models.Canto, error)
+	FindPassage(ctx context.Context, citation models.Citation) ([]models.Canto, error)
+	// Insert fails with ErrDuplicate when the verse already exists
+	Insert(ctx context.Context, canto models.Canto) error
+	// Update sets the given fields, see cantoValues, and fails with ErrNotFound when the verse does not exist
+	Update(ctx context.Context, book string, arabic int, verse int, values map[string]interface{}) (models.Canto, error)
+	// Save replaces the text of a verse or creates it when it does not exist
+	Save(ctx context.Context, canto models.Canto) (models.Canto, error)
+	Delete(ctx context.Context, book string, arabic int, verse int) (models.Canto, error)
+}
+
+var repo CantoRepository
+
+// TranslationRepository stores the metadata of the translations, their text is kept on the canti
+type TranslationRepository interface {
+	FindAll(ctx context.Context) ([]models.Translation, error)
+	// Find fails with ErrNotFound when there is no translation with the id
+	Find(ctx context.Context, id string) (models.Translation, error)
+	// Save creates the translation or replaces its metadata
+	Save(ctx context.Context, translation models.Translation) error
+}
+
+var translations TranslationRepository
+
+// AnnotationFilter narrows down the annotations of a canto, zero values do not filter
+type AnnotationFilter struct {
+	Verse  int
+	Tag    string
+	Author string
+}
+
+// AnnotationRepository stores the notes scholars make on the verses
+type AnnotationRepository interface {
+	// FindByCanto returns the annotations of a canto ordered by the verse they start on
+	FindByCanto(ctx context.Context, book string, arabic int, filter AnnotationFilter) ([]models.Annotation, error)
+	// Find fails with ErrNotFound when there is no annotation with the id
+	Find(ctx context.Context, id primitive.ObjectID) (models.Annotation, error)
+	Insert(ctx context.Context, annotation models.Annotation) error
+	Replace(ctx context.Context, annotation models.Annotation) error
+	Delete(ctx context.Context, id primitive.ObjectID) error
+}
+
+var annotations AnnotationRepository
+
+// RevisionRepository keeps the history of the verses, revisions are only ever inserted
+type RevisionRepository interface {
+	// FindByVerse returns the revisions of a verse, the latest first
+	FindByVerse(ctx context.Context, book string, arabic int, verse int) ([]Revision, error)
+	// Find fails with ErrNotFound when the verse has no revision with the id
+	Find(ctx context.Context, book string, arabic int, verse int, id primitive.ObjectID) (Revision, error)
+	Insert(ctx context.Context, revision Revision) error
+}
+
+var revisions RevisionRepository
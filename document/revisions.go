@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/joerivrij/microbases/shared/models"
+	"github.com/joerivrij/microbases/shared/response"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	openlog "github.com/opentracing/opentracing-go/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const REVISIONS = "revisions"
+
+const (
+	actionCreate  = "create"
+	actionUpdate  = "update"
+	actionDelete  = "delete"
+	actionRestore = "restore"
+)
+
+// Revision records a single write to a verse, revisions are only ever inserted
+type Revision struct {
+	ID        primitive.ObjectID     `bson:"_id" json:"id"`
+	Book      string                 `bson:"book" json:"book"`
+	Arabic    int                    `bson:"arabic" json:"arabic"`
+	Verse     int                    `bson:"verse" json:"verse"`
+	Action    string                 `bson:"action" json:"action"`
+	Author    string                 `bson:"author" json:"author"`
+	Timestamp time.Time              `bson:"timestamp" json:"timestamp"`
+	Changes   map[string]FieldChange `bson:"changes" json:"changes"`
+	// Snapshot is the verse as it was after this revision, empty when the verse was deleted
+	Snapshot *models.Canto `bson:"snapshot,omitempty" json:"snapshot,omitempty"`
+}
+
+type FieldChange struct {
+	Old interface{} `bson:"old" json:"old"`
+	New interface{} `bson:"new" json:"new"`
+}
+
+func revisionsHandler(w http.ResponseWriter, req *http.Request) {
+	spanCtx, _ := opentracing.GlobalTracer().Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(req.Header))
+	span := opentracing.GlobalTracer().StartSpan("revisionsHandler", ext.RPCServerOption(spanCtx))
+	defer span.Finish()
+
+	ctx := opentracing.ContextWithSpan(req.Context(), span)
+
+	span.LogFields(
+		openlog.String("method", req.Method),
+		openlog.String("path", req.URL.Path),
+		openlog.String("host", req.Host),
+	)
+
+	book, arabic, verse, err := parseVerseVars(mux.Vars(req))
+	if err != nil {
+		response.RespondWithError(w, http.StatusBadRequest, err.Error(), ctx)
+		return
+	}
+
+	found, err := revisions.FindByVerse(ctx, book, arabic, verse)
+	if err != nil {
+		respondWithStoreError(w, err, ctx)
+		return
+	}
+
+	response.RespondWithJson(w, http.StatusOK, found, ctx)
+}
+
+func restoreRevisionHandler(w http.ResponseWriter, req *http.Request) {
+	spanCtx, _ := opentracing.GlobalTracer().Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(req.Header))
+	span := opentracing.GlobalTracer().StartSpan("restoreRevisionHandler", ext.RPCServerOption(spanCtx))
+	defer span.Finish()
+
+	ctx := opentracing.ContextWithSpan(req.Context(), span)
+
+	span.LogFields(
+		openlog.String("method", req.Method),
+		openlog.String("path", req.URL.Path),
+		openlog.String("host", req.Host),
+	)
+
+	vars := mux.Vars(req)
+	book, arabic, verse, err := parseVerseVars(vars)
+	if err != nil {
+		response.RespondWithError(w, http.StatusBadRequest, err.Error(), ctx)
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(vars["revision"])
+	if err != nil {
+		response.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("revision %q is not a valid id", vars["revision"]), ctx)
+		return
+	}
+
+	revision, err := revisions.Find(ctx, book, arabic, verse, id)
+	if err == ErrNotFound {
+		response.RespondWithError(w, http.StatusNotFound, "revision not found", ctx)
+		return
+	}
+	if err != nil {
+		respondWithStoreError(w, err, ctx)
+		return
+	}
+	if revision.Snapshot == nil {
+		response.RespondWithError(w, http.StatusConflict, "a deleted verse cannot be restored to its deletion", ctx)
+		return
+	}
+
+	restored := *revision.Snapshot
+	err = inTransaction(ctx, func(ctx context.Context) error {
+		before, err := repo.Save(ctx, *revision.Snapshot)
+		if err != nil {
+			return err
+		}
+		if !before.ID.IsZero() {
+			restored.ID = before.ID
+		}
+		return recordRevision(ctx, req, actionRestore, before, &restored)
+	})
+	if err != nil {
+		respondWithStoreError(w, err, ctx)
+		return
+	}
+	invalidateConcordance()
+
+	response.RespondWithJson(w, http.StatusOK, restored, ctx)
+}
+
+// inTransaction runs write so the change to a verse and its revision are stored together or not at all,
+// with mongo that takes a transaction and so a replica set. The memory store cannot fail halfway
+func inTransaction(ctx context.Context, write func(ctx context.Context) error) error {
+	if db == nil {
+		return write(ctx)
+	}
+
+	session, err := db.Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessionCtx mongo.SessionContext) (interface{}, error) {
+		return nil, write(sessionCtx)
+	})
+	return err
+}
+
+// recordRevision stores what changed between two versions of a verse. It runs in the transaction of
+// the write it describes, when it fails the write is rolled back with it
+func recordRevision(ctx context.Context, req *http.Request, action string, before models.Canto, after *models.Canto) error {
+	span, _ := opentracing.StartSpanFromContext(ctx, "recordRevision")
+	defer span.Finish()
+
+	afterValues := map[string]interface{}{}
+	verse := before
+	if after != nil {
+		afterValues = cantoValues(*after)
+		verse = *after
+	}
+	beforeValues := map[string]interface{}{}
+	if before.Book != "" {
+		beforeValues = cantoValues(before)
+	}
+
+	changes := diffValues(beforeValues, afterValues)
+	if len(changes) == 0 {
+		return nil
+	}
+
+	revision := Revision{
+		ID:        primitive.NewObjectID(),
+		Book:      verse.Book,
+		Arabic:    verse.Arabic,
+		Verse:     verse.Verse,
+		Action:    action,
+		Author:    requestAuthor(ctx, req),
+		Timestamp: time.Now().UTC(),
+		Changes:   changes,
+		Snapshot:  after,
+	}
+
+	if err := revisions.Insert(ctx, revision); err != nil {
+		return err
+	}
+
+	span.LogFields(
+		openlog.String("revision", revision.ID.Hex()),
+		openlog.String("author", revision.Author),
+	)
+	return nil
+}
+
+// cantoValues returns the fields of a verse that can be edited by their stored name
+func cantoValues(canto models.Canto) map[string]interface{} {
+	return map[string]interface{}{
+		"title":        canto.Title,
+		"roman":        canto.Roman,
+		"words":        canto.Words,
+		"textItalian":  canto.TextItalian,
+		"translations": copyTranslations(canto.Translations),
+	}
+}
+
+// applyValues returns the verse with the given stored fields set, it is the counterpart of cantoValues
+func applyValues(canto models.Canto, values map[string]interface{}) models.Canto {
+	for field, value := range values {
+		switch field {
+		case "title":
+			canto.Title = value.(string)
+		case "roman":
+			canto.Roman = value.(string)
+		case "words":
+			canto.Words = value.(int)
+		case "textItalian":
+			canto.TextItalian = value.(string)
+		case "translations":
+			canto.Translations = copyTranslations(value.(map[string]string))
+		default:
+			// a single translation is set as translations.<id>, a nil value removes it
+			if id := strings.TrimPrefix(field, "translations."); id != field {
+				canto.Translations = copyTranslations(canto.Translations)
+				if value == nil {
+					delete(canto.Translations, id)
+				} else {
+					canto.Translations[id] = value.(string)
+				}
+			}
+		}
+	}
+	return canto
+}
+
+// copyTranslations keeps the verses stored in memory from sharing their translations with the ones handed out
+func copyTranslations(translations map[string]string) map[string]string {
+	copied := make(map[string]string, len(translations))
+	for id, text := range translations {
+		copied[id] = text
+	}
+	return copied
+}
+
+func diffValues(before map[string]interface{}, after map[string]interface{}) map[string]FieldChange {
+	changes := map[string]FieldChange{}
+	for field, value := range after {
+		if old, ok := before[field]; !ok || !reflect.DeepEqual(old, value) {
+			changes[field] = FieldChange{Old: before[field], New: value}
+		}
+	}
+	for field, value := range before {
+		if _, ok := after[field]; !ok {
+			changes[field] = FieldChange{Old: value, New: nil}
+		}
+	}
+	return changes
+}
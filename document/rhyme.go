@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/joerivrij/microbases/shared/models"
+	"github.com/joerivrij/microbases/shared/response"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	openlog "github.com/opentracing/opentracing-go/log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// RhymeAnalysis describes how a canto follows the terza rima (ABA BCB CDC ... YZY Z)
+type RhymeAnalysis struct {
+	Book    string       `json:"book"`
+	Canto   int          `json:"canto"`
+	Scheme  string       `json:"scheme"`
+	Tercets []Tercet     `json:"tercets"`
+	Chain   []RhymeLink  `json:"chain"`
+	Breaks  []RhymeBreak `json:"breaks"`
+}
+
+// Tercet is a group of three verses, the closing verse of a canto forms a tercet of its own
+type Tercet struct {
+	Number int             `json:"number"`
+	Verses []AnalyzedVerse `json:"verses"`
+}
+
+type AnalyzedVerse struct {
+	Verse  int    `json:"verse"`
+	Text   string `json:"text"`
+	Rhyme  string `json:"rhyme"`
+	Letter string `json:"letter"`
+}
+
+// RhymeLink is one rhyme of the chain together with the verses that should carry it
+type RhymeLink struct {
+	Letter string `json:"letter"`
+	Rhyme  string `json:"rhyme"`
+	Verses []int  `json:"verses"`
+}
+
+// RhymeBreak is a verse that does not rhyme with the verses it should rhyme with
+type RhymeBreak struct {
+	Verse    int    `json:"verse"`
+	Letter   string `json:"letter"`
+	Expected string `json:"expected"`
+	Found    string `json:"found"`
+}
+
+func rhymeHandler(w http.ResponseWriter, req *http.Request) {
+	spanCtx, _ := opentracing.GlobalTracer().Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(req.Header))
+	span := opentracing.GlobalTracer().StartSpan("rhymeHandler", ext.RPCServerOption(spanCtx))
+	defer span.Finish()
+
+	ctx := opentracing.ContextWithSpan(req.Context(), span)
+
+	span.LogFields(
+		openlog.String("method", req.Method),
+		openlog.String("path", req.URL.Path),
+		openlog.String("host", req.Host),
+	)
+
+	book, arabic, err := parseCantoVars(mux.Vars(req))
+	if err != nil {
+		response.RespondWithError(w, http.StatusBadRequest, err.Error(), ctx)
+		return
+	}
+
+	verses, _, err := repo.FindByCanto(ctx, book, arabic, ListOptions{Sort: []string{"verse"}})
+	if err != nil {
+		respondWithStoreError(w, err, ctx)
+		return
+	}
+	if len(verses) == 0 {
+		response.RespondWithError(w, http.StatusNotFound, fmt.Sprintf("%s canto %d not found", book, arabic), ctx)
+		return
+	}
+
+	analysis := analyzeTerzaRima(verses)
+	span.LogFields(
+		openlog.Int("breaks", len(analysis.Breaks)),
+	)
+
+	response.RespondWithCacheableJson(w, req, analysis, time.Time{}, ctx)
+}
+
+// analyzeTerzaRima expects the verses of one canto ordered by verse number. In terza rima the outer
+// verses of a tercet share a rhyme and the middle verse sets the rhyme of the next tercet, so every
+// rhyme is expected on exactly the verses rhymeGroup assigns to it
+func analyzeTerzaRima(verses []models.Canto) RhymeAnalysis {
+	analysis := RhymeAnalysis{
+		Book:    verses[0].Book,
+		Canto:   verses[0].Arabic,
+		Tercets: []Tercet{},
+		Chain:   []RhymeLink{},
+		Breaks:  []RhymeBreak{},
+	}
+
+	var groups [][]int
+	var endings [][]string
+	for _, verse := range verses {
+		group := rhymeGroup(verse.Verse)
+		for len(groups) <= group {
+			groups = append(groups, nil)
+			endings = append(endings, nil)
+		}
+		groups[group] = append(groups[group], verse.Verse)
+		endings[group] = append(endings[group], rhymeEnding(lastWord(verse.TextItalian)))
+	}
+
+	rhymes := make([]string, len(groups))
+	for group, members := range groups {
+		if len(members) == 0 {
+			continue
+		}
+		rhymes[group] = mostCommon(endings[group])
+		analysis.Chain = append(analysis.Chain, RhymeLink{
+			Letter: rhymeLetter(group),
+			Rhyme:  rhymes[group],
+			Verses: members,
+		})
+	}
+
+	var scheme []string
+	for _, verse := range verses {
+		group := rhymeGroup(verse.Verse)
+		rhyme := rhymeEnding(lastWord(verse.TextItalian))
+		analyzed := AnalyzedVerse{
+			Verse:  verse.Verse,
+			Text:   verse.TextItalian,
+			Rhyme:  rhyme,
+			Letter: rhymeLetter(group),
+		}
+
+		if rhyme != rhymes[group] {
+			analysis.Breaks = append(analysis.Breaks, RhymeBreak{
+				Verse:    verse.Verse,
+				Letter:   analyzed.Letter,
+				Expected: rhymes[group],
+				Found:    rhyme,
+			})
+		}
+
+		number := (verse.Verse-1)/3 + 1
+		if len(analysis.Tercets) == 0 || analysis.Tercets[len(analysis.Tercets)-1].Number != number {
+			analysis.Tercets = append(analysis.Tercets, Tercet{Number: number})
+			scheme = append(scheme, "")
+		}
+		last := len(analysis.Tercets) - 1
+		analysis.Tercets[last].Verses = append(analysis.Tercets[last].Verses, analyzed)
+		scheme[last] += analyzed.Letter
+	}
+	analysis.Scheme = strings.Join(scheme, " ")
+
+	return analysis
+}
+
+// rhymeGroup returns the index of the rhyme a verse should carry, verse 1 and 3 get rhyme 0 (A),
+// verse 2, 4 and 6 get rhyme 1 (B) and so on
+func rhymeGroup(verse int) int {
+	switch verse % 3 {
+	case 1:
+		return (verse - 1) / 3
+	case 2:
+		return (verse-2)/3 + 1
+	default:
+		return verse/3 - 1
+	}
+}
+
+func rhymeLetter(group int) string {
+	letter := string(rune('A' + group%26))
+	if group >= 26 {
+		return strings.Repeat(letter, group/26+1)
+	}
+	return letter
+}
+
+func mostCommon(values []string) string {
+	counts := map[string]int{}
+	best := values[0]
+	for _, value := range values {
+		counts[value]++
+		if counts[value] > counts[best] {
+			best = value
+		}
+	}
+	return best
+}
+
+func lastWord(text string) string {
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	if len(words) == 0 {
+		return ""
+	}
+	return strings.ToLower(words[len(words)-1])
+}
+
+// rhymeEnding approximates the italian rhyme of a word: everything from the stressed vowel on.
+// Most italian words are stressed on the second to last vowel (vita, oscura), words with a written
+// accent on the last vowel are stressed there (città, più). Accents are dropped so più rhymes with fu
+func rhymeEnding(word string) string {
+	letters := []rune(word)
+
+	var vowels []int
+	for i, r := range letters {
+		if _, ok := accented[r]; ok {
+			if i == len(letters)-1 {
+				return string(accented[r])
+			}
+		}
+		if isVowel(r) {
+			vowels = append(vowels, i)
+		}
+	}
+
+	if len(vowels) == 0 {
+		return removeAccents(word)
+	}
+	start := vowels[0]
+	if len(vowels) > 1 {
+		start = vowels[len(vowels)-2]
+	}
+	return removeAccents(string(letters[start:]))
+}
+
+var accented = map[rune]rune{
+	'à': 'a', 'è': 'e', 'é': 'e', 'ì': 'i', 'í': 'i', 'ò': 'o', 'ó': 'o', 'ù': 'u', 'ú': 'u',
+}
+
+func isVowel(r rune) bool {
+	if _, ok := accented[r]; ok {
+		return true
+	}
+	return strings.ContainsRune("aeiou", r)
+}
+
+func removeAccents(text string) string {
+	return strings.Map(func(r rune) rune {
+		if plain, ok := accented[r]; ok {
+			return plain
+		}
+		return r
+	}, text)
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/joerivrij/microbases/shared/models"
+	"github.com/joerivrij/microbases/shared/response"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	openlog "github.com/opentracing/opentracing-go/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"html"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// SearchHit is a single verse matching a search together with its relevance
+type SearchHit struct {
+	Canto   models.Canto `json:"canto"`
+	Score   float64      `json:"score"`
+	Snippet string       `json:"snippet"`
+}
+
+type scoredCanto struct {
+	models.Canto
+	Score float64
+}
+
+// UnmarshalBSON reads the verse and its score from the same document, the verse reads itself so a
+// canto that has not been migrated keeps its english text
+func (s *scoredCanto) UnmarshalBSON(data []byte) error {
+	var score struct {
+		Score float64 `bson:"score"`
+	}
+	if err := bson.Unmarshal(data, &score); err != nil {
+		return err
+	}
+	s.Score = score.Score
+	return bson.Unmarshal(data, &s.Canto)
+}
+
+func searchHandler(w http.ResponseWriter, req *http.Request) {
+	spanCtx, _ := opentracing.GlobalTracer().Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(req.Header))
+	span := opentracing.GlobalTracer().StartSpan("searchHandler", ext.RPCServerOption(spanCtx))
+	defer span.Finish()
+
+	ctx := opentracing.ContextWithSpan(req.Context(), span)
+
+	span.LogFields(
+		openlog.String("method", req.Method),
+		openlog.String("path", req.URL.Path),
+		openlog.String("host", req.Host),
+	)
+
+	if !requireMongo(w, ctx) {
+		return
+	}
+
+	q := strings.TrimSpace(req.URL.Query().Get("q"))
+	if q == "" {
+		response.RespondWithError(w, http.StatusBadRequest, "query parameter q is required", ctx)
+		return
+	}
+
+	source, err := parseTextSource(req.URL.Query())
+	if err != nil {
+		response.RespondWithError(w, http.StatusBadRequest, err.Error(), ctx)
+		return
+	}
+
+	limit := defaultSearchLimit
+	if value := req.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxSearchLimit {
+			response.RespondWithError(w, http.StatusBadRequest, "limit has to be between 1 and 100", ctx)
+			return
+		}
+		limit = parsed
+	}
+
+	hits, err := searchVerses(ctx, q, source, limit)
+	if err != nil {
+		respondWithStoreError(w, err, ctx)
+		return
+	}
+
+	response.RespondWithJson(w, http.StatusOK, hits, ctx)
+}
+
+// searchVerses runs a text search and only keeps the verses where the requested text matched, the
+// text index covers the original and every translation so a query for "wood" could otherwise return italian hits
+func searchVerses(ctx context.Context, q string, source string, limit int) ([]SearchHit, error) {
+	span, _ := opentracing.StartSpanFromContext(ctx, "searchVerses")
+	defer span.Finish()
+
+	span.LogFields(
+		openlog.String("query", q),
+		openlog.String("text", textField(source)),
+	)
+
+	hits := []SearchHit{}
+	terms := searchTerms(q)
+	if len(terms) == 0 {
+		return hits, nil
+	}
+
+	pattern := "(" + strings.Join(terms, "|") + ")"
+
+	queryCtx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	var found []scoredCanto
+	query := textFilter(source, primitive.Regex{Pattern: pattern, Options: "i"})
+	query["$text"] = bson.M{"$search": q}
+	score := bson.M{"$meta": "textScore"}
+	findOptions := options.Find().
+		SetProjection(bson.M{"score": score}).
+		SetSort(bson.D{{Key: "score", Value: score}}).
+		SetLimit(int64(limit))
+
+	cursor, err := db.Collection(COLLECTION).Find(queryCtx, query, findOptions)
+	if err == nil {
+		err = cursor.All(queryCtx, &found)
+	}
+	if err != nil {
+		span.LogFields(
+			openlog.String("mongoresult", "error searching canti"),
+			openlog.Error(err),
+		)
+		return nil, err
+	}
+
+	highlight := regexp.MustCompile("(?i)" + pattern)
+	for _, result := range found {
+		text := textOf(result.Canto, source)
+
+		hits = append(hits, SearchHit{
+			Canto:   result.Canto,
+			Score:   result.Score,
+			Snippet: highlightSnippet(text, highlight),
+		})
+	}
+
+	response, _ := json.Marshal(hits)
+	span.LogFields(
+		openlog.String("mongoresult", string(response)),
+	)
+
+	return hits, nil
+}
+
+// highlightSnippet wraps the matches in <em>, everything else in the text is escaped so the snippet is
+// safe to use as html. The matches are found in the text itself so terms with an apostrophe still match
+func highlightSnippet(text string, highlight *regexp.Regexp) string {
+	var snippet strings.Builder
+	last := 0
+	for _, match := range highlight.FindAllStringIndex(text, -1) {
+		snippet.WriteString(html.EscapeString(text[last:match[0]]))
+		snippet.WriteString("<em>" + html.EscapeString(text[match[0]:match[1]]) + "</em>")
+		last = match[1]
+	}
+	snippet.WriteString(html.EscapeString(text[last:]))
+	return snippet.String()
+}
+
+// searchTerms returns the escaped search terms that have to show up in a hit,
+// negated terms are skipped since they never appear in the text
+func searchTerms(q string) []string {
+	var terms []string
+	for _, term := range strings.Fields(q) {
+		term = strings.Trim(term, `"`)
+		if term == "" || strings.HasPrefix(term, "-") {
+			continue
+		}
+		terms = append(terms, regexp.QuoteMeta(term))
+	}
+	return terms
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"regexp"
+	"testing"
+)
+
+func TestHighlightSnippet(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		terms   string
+		snippet string
+	}{
+		{"single term", "Nel mezzo del cammin", "mezzo", "Nel <em>mezzo</em> del cammin"},
+		{"any case", "Nel mezzo del cammin", "nel", "<em>Nel</em> mezzo del cammin"},
+		{"several terms", "Nel mezzo del cammin", "(mezzo|cammin)", "Nel <em>mezzo</em> del <em>cammin</em>"},
+		{"markup in the text", "a <b>bold</b> & selva", "selva", "a &lt;b&gt;bold&lt;/b&gt; &amp; <em>selva</em>"},
+		{"apostrophe in a term", "l'anima mia", "l'anima", "<em>l&#39;anima</em> mia"},
+		{"no match", "Nel mezzo", "selva", "Nel mezzo"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			snippet := highlightSnippet(test.text, regexp.MustCompile("(?i)"+test.terms))
+			if snippet != test.snippet {
+				t.Errorf("expected %q, got %q", test.snippet, snippet)
+			}
+		})
+	}
+}
+
+func TestScoredCantoKeepsLegacyEnglish(t *testing.T) {
+	data, _ := bson.Marshal(bson.M{"book": "Inferno", "arabic": 1, "verse": 2, "textEnglish": "I found me", "score": 1.5})
+
+	var found scoredCanto
+	if err := bson.Unmarshal(data, &found); err != nil {
+		t.Fatal(err)
+	}
+	if found.Score != 1.5 || found.Verse != 2 {
+		t.Errorf("expected verse 2 with score 1.5, got verse %d with score %v", found.Verse, found.Score)
+	}
+	if text := textOf(found.Canto, "default"); text != "I found me" {
+		t.Errorf("expected the english text of an unmigrated canto, got %q", text)
+	}
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"github.com/gorilla/mux"
 	"github.com/joerivrij/microbases/shared/models"
@@ -12,29 +11,126 @@ import (
 	"github.com/opentracing/opentracing-go"
 	"github.com/opentracing/opentracing-go/ext"
 	openlog "github.com/opentracing/opentracing-go/log"
-	"gopkg.in/mgo.v2"
-	"gopkg.in/mgo.v2/bson"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"log"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
 
-var db *mgo.Database
+var db *mongo.Database
 
 const (
 	COLLECTION = "canti"
 	DATABASE = "divinacommedia"
 )
 
-func Connect(mongoUrl string) {
-	session, err := mgo.Dial(mongoUrl)
+const (
+	connectTimeout = 10 * time.Second
+	// queryTimeout bounds every single query, handlers should never hang on a slow mongo
+	queryTimeout = 5 * time.Second
+)
+
+func Connect(mongoUrl string) error {
+	if !strings.Contains(mongoUrl, "://") {
+		mongoUrl = "mongodb://" + mongoUrl
+	}
+
+	maxPoolSize := uint64(50)
+	if value := os.Getenv("MONGO_MAX_POOL_SIZE"); value != "" {
+		size, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("MONGO_MAX_POOL_SIZE %q is not a number", value)
+		}
+		maxPoolSize = size
+	}
+
+	clientOptions := options.Client().
+		ApplyURI(mongoUrl).
+		SetMaxPoolSize(maxPoolSize).
+		SetMinPoolSize(5).
+		SetMaxConnIdleTime(5 * time.Minute).
+		SetConnectTimeout(connectTimeout).
+		SetServerSelectionTimeout(connectTimeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, clientOptions)
+	if err != nil {
+		return err
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		return err
+	}
+	db = client.Database(DATABASE)
+
+	// book, canto and verse together identify a verse so they have to be unique
+	_, err = db.Collection(COLLECTION).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "book", Value: 1}, {Key: "arabic", Value: 1}, {Key: "verse", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		println(err.Error())
+	}
+
+	// a collection can only have one text index so it covers the original and every translation,
+	// which are not known up front. No stemming is done so words are matched as they are written
+	specifications, err := db.Collection(COLLECTION).Indexes().ListSpecifications(ctx)
+	if err == nil {
+		for _, index := range specifications {
+			// the first text index only covered textItalian and textEnglish
+			if index.Name == "verse_text" {
+				db.Collection(COLLECTION).Indexes().DropOne(ctx, index.Name)
+			}
+		}
+	}
+	_, err = db.Collection(COLLECTION).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "$**", Value: "text"}},
+		Options: options.Index().SetName("canti_text").SetDefaultLanguage("none"),
+	})
+	if err != nil {
+		println(err.Error())
+	}
+
+	// deleted verses are only known by their id in the change stream unless mongo keeps their pre-image
+	err = db.RunCommand(ctx, bson.D{
+		{Key: "collMod", Value: COLLECTION},
+		{Key: "changeStreamPreAndPostImages", Value: bson.M{"enabled": true}},
+	}).Err()
 	if err != nil {
-		println(err)
+		println(err.Error())
 	}
-	db = session.DB(DATABASE)
+
+	_, err = db.Collection(ANNOTATIONS).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "book", Value: 1}, {Key: "arabic", Value: 1}, {Key: "from", Value: 1}},
+	})
+	if err != nil {
+		println(err.Error())
+	}
+
+	_, err = db.Collection(REVISIONS).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "book", Value: 1}, {Key: "arabic", Value: 1}, {Key: "verse", Value: 1}, {Key: "timestamp", Value: -1}},
+	})
+	if err != nil {
+		println(err.Error())
+	}
+
+	// verses can only use known translations, a fresh database needs the default one textEnglish is written to.
+	// Metadata set by the migrate command or PUT /api/translations/default is left alone
+	_, err = db.Collection(TRANSLATIONS).UpdateOne(ctx, bson.M{"_id": defaultTranslation.ID}, bson.M{
+		"$setOnInsert": bson.M{"language": defaultTranslation.Language},
+	}, options.Update().SetUpsert(true))
+	if err != nil {
+		println(err.Error())
+	}
+
+	return nil
 }
 
 func main() {
@@ -50,7 +146,39 @@ func main() {
 	mongoUrl := os.Getenv("MONGO_URL")
 	println(mongoUrl)
 
-	Connect(mongoUrl)
+	// DOCUMENT_STORE=memory runs the service without mongo, search is not available then
+	if os.Getenv("DOCUMENT_STORE") == "memory" {
+		mongoUrl = "memory"
+		repo = NewMemoryRepository()
+		translations = NewMemoryTranslationRepository(defaultTranslation)
+		annotations = NewMemoryAnnotationRepository()
+		revisions = NewMemoryRevisionRepository()
+	} else {
+		if err := Connect(mongoUrl); err != nil {
+			log.Fatal("Could not connect to mongo: ", err)
+		}
+		repo = NewMongoRepository(db)
+		translations = NewMongoTranslationRepository(db)
+		annotations = NewMongoAnnotationRepository(db)
+		revisions = NewMongoRevisionRepository(db)
+
+		// every change to the canti is published as an event when kafka is configured
+		if brokers := os.Getenv("KAFKA_BROKERS"); brokers != "" {
+			topic := os.Getenv("KAFKA_TOPIC")
+			if topic == "" {
+				topic = "canti"
+			}
+			writer := newEventWriter(brokers, topic)
+			defer writer.Close()
+			go watchCanti(context.Background(), writer)
+		}
+	}
+
+	// annotations are written with a token of the oauth server, it names the author
+	OauthUrl = os.Getenv("OAUTH_URL")
+	if OauthUrl == "" {
+		println("OAUTH_URL is not set, annotations cannot be written and revisions are made by anonymous")
+	}
 
 	jaegerUrl := os.Getenv("JAEGER_AGENT_HOST")
 	jaegerPort :=  os.Getenv("JAEGER_AGENT_PORT")
@@ -70,61 +198,94 @@ func main() {
 		port = "3210"
 	}
 
-	ctx := context.Background()
-	ctx = opentracing.ContextWithSpan(ctx, span)
+	ctx := opentracing.ContextWithSpan(context.Background(), span)
 
-	logValue := fmt.Sprintf("Starting server on port %s with mongodb %s", port, db)
+	logValue := fmt.Sprintf("Starting server on port %s with mongodb %s", port, mongoUrl)
 	tracing.PrintServerInfo(ctx, logValue)
 	span.Finish()
 
+	panic(http.ListenAndServe(":"+port, newRouter()))
+}
+
+func newRouter() *mux.Router {
 	r := mux.NewRouter()
+	r.HandleFunc("/api/search", searchHandler).Methods("GET")
+	r.HandleFunc("/api/stats", statsHandler).Methods("GET")
+	r.HandleFunc("/api/books", booksHandler).Methods("GET")
+	r.HandleFunc("/api/cite", citationHandler).Methods("GET")
+	r.HandleFunc("/api/concordance", concordanceHandler).Methods("GET")
+	r.HandleFunc("/api/translations", translationsHandler).Methods("GET")
+	r.HandleFunc("/api/annotations/{annotation}", annotationHandler).Methods("GET")
+	r.HandleFunc("/api/annotations/{annotation}", replaceAnnotationHandler).Methods("PUT")
+	r.HandleFunc("/api/annotations/{annotation}", deleteAnnotationHandler).Methods("DELETE")
+	r.HandleFunc("/api/translations/{translation}", translationHandler).Methods("GET")
+	r.HandleFunc("/api/translations/{translation}", saveTranslationHandler).Methods("PUT")
 	r.HandleFunc("/api/{book}", allCantiHandler).Methods("GET")
 	r.HandleFunc("/api/{book}/{canto}", specificCantoHandler).Methods("GET")
+	r.HandleFunc("/api/{book}/{canto}/rhymes", rhymeHandler).Methods("GET")
+	r.HandleFunc("/api/{book}/{canto}/parallel", parallelCantoHandler).Methods("GET")
+	r.HandleFunc("/api/{book}/{canto}/annotated", annotatedCantoHandler).Methods("GET")
+	r.HandleFunc("/api/{book}/{canto}/annotations", cantoAnnotationsHandler).Methods("GET")
+	r.HandleFunc("/api/{book}/{canto}/annotations", createAnnotationHandler).Methods("POST")
+	r.HandleFunc("/api/{book}/{canto}/{from:[0-9]+}-{to:[0-9]+}", verseRangeHandler).Methods("GET")
 	r.HandleFunc("/api/{book}/{canto}/{verse}", specificCantoWithVerseHandler).Methods("GET")
-
-	panic(http.ListenAndServe(":"+port, r))
+	r.HandleFunc("/api/{book}/{canto}/{verse}", createCantoHandler).Methods("POST")
+	r.HandleFunc("/api/{book}/{canto}/{verse}", replaceCantoHandler).Methods("PUT")
+	r.HandleFunc("/api/{book}/{canto}/{verse}", patchCantoHandler).Methods("PATCH")
+	r.HandleFunc("/api/{book}/{canto}/{verse}", deleteCantoHandler).Methods("DELETE")
+	r.HandleFunc("/api/{book}/{canto}/{verse}/revisions", revisionsHandler).Methods("GET")
+	r.HandleFunc("/api/{book}/{canto}/{verse}/translations/{translation}", verseTranslationHandler).Methods("GET")
+	r.HandleFunc("/api/{book}/{canto}/{verse}/revisions/{revision}/restore", restoreRevisionHandler).Methods("POST")
+
+	return r
 }
 
 func specificCantoHandler(w http.ResponseWriter, req *http.Request) {
-	vars := mux.Vars(req)
-	book := strings.Title(vars["book"])
-	canto := vars["canto"]
-
 	spanCtx, _ := opentracing.GlobalTracer().Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(req.Header))
-	span := opentracing.GlobalTracer().StartSpan("postHandler", ext.RPCServerOption(spanCtx))
+	span := opentracing.GlobalTracer().StartSpan("specificCantoHandler", ext.RPCServerOption(spanCtx))
 	defer span.Finish()
 
-	ctx := context.Background()
-	ctx = opentracing.ContextWithSpan(ctx, span)
+	ctx := opentracing.ContextWithSpan(req.Context(), span)
 
 	span.LogFields(
 		openlog.String("method", req.Method),
 		openlog.String("path", req.URL.Path),
 		openlog.String("host", req.Host),
 	)
-	arabic, err := strconv.Atoi(canto)
+
+	book, arabic, err := parseCantoVars(mux.Vars(req))
 	if err != nil {
-		fmt.Println(err)
+		response.RespondWithError(w, http.StatusBadRequest, err.Error(), ctx)
+		return
 	}
-	query := bson.M{"book": book, "arabic": arabic}
 
-	result := findAllWithQuery(ctx, query)
+	options, err := parseListOptions(req.URL.Query())
+	if err != nil {
+		response.RespondWithError(w, http.StatusBadRequest, err.Error(), ctx)
+		return
+	}
+
+	result, total, err := repo.FindByCanto(ctx, book, arabic, options)
+	if err != nil {
+		respondWithStoreError(w, err, ctx)
+		return
+	}
+	if total == 0 {
+		response.RespondWithError(w, http.StatusNotFound, fmt.Sprintf("%s canto %d not found", book, arabic), ctx)
+		return
+	}
 
-	response.RespondWithJson(w, http.StatusOK, result, ctx)
+	setPagingHeaders(w, req, options, total)
+	// a list has no Last-Modified, deleting a verse would not move it forward, so lists are only validated with the ETag
+	response.RespondWithCacheableJson(w, req, options.project(result), time.Time{}, ctx)
 }
 
 func specificCantoWithVerseHandler(w http.ResponseWriter, req *http.Request) {
-	vars := mux.Vars(req)
-	book := strings.Title(vars["book"])
-	canto := vars["canto"]
-	verse := vars["verse"]
-
 	spanCtx, _ := opentracing.GlobalTracer().Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(req.Header))
-	span := opentracing.GlobalTracer().StartSpan("postHandler", ext.RPCServerOption(spanCtx))
+	span := opentracing.GlobalTracer().StartSpan("specificCantoWithVerseHandler", ext.RPCServerOption(spanCtx))
 	defer span.Finish()
 
-	ctx := context.Background()
-	ctx = opentracing.ContextWithSpan(ctx, span)
+	ctx := opentracing.ContextWithSpan(req.Context(), span)
 
 	span.LogFields(
 		openlog.String("method", req.Method),
@@ -132,31 +293,31 @@ func specificCantoWithVerseHandler(w http.ResponseWriter, req *http.Request) {
 		openlog.String("host", req.Host),
 	)
 
-	arabic, err := strconv.Atoi(canto)
+	book, arabic, verse, err := parseVerseVars(mux.Vars(req))
 	if err != nil {
-		fmt.Println(err)
+		response.RespondWithError(w, http.StatusBadRequest, err.Error(), ctx)
+		return
 	}
 
-	verseBson, err := strconv.Atoi(verse)
+	result, err := repo.FindVerse(ctx, book, arabic, verse)
+	if err == ErrNotFound {
+		response.RespondWithError(w, http.StatusNotFound, fmt.Sprintf("%s %d:%d not found", book, arabic, verse), ctx)
+		return
+	}
 	if err != nil {
-		fmt.Println(err)
+		respondWithStoreError(w, err, ctx)
+		return
 	}
-	query := bson.M{"book": book, "arabic": arabic, "verse": verseBson}
-	result := findOneWithQuery(ctx, query)
 
-	response.RespondWithJson(w, http.StatusOK, result, ctx)
+	response.RespondWithCacheableJson(w, req, result, result.Modified, ctx)
 }
 
 func allCantiHandler(w http.ResponseWriter, req *http.Request) {
-	vars := mux.Vars(req)
-	book := strings.Title(vars["book"])
-
 	spanCtx, _ := opentracing.GlobalTracer().Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(req.Header))
-	span := opentracing.GlobalTracer().StartSpan("postHandler", ext.RPCServerOption(spanCtx))
+	span := opentracing.GlobalTracer().StartSpan("allCantiHandler", ext.RPCServerOption(spanCtx))
 	defer span.Finish()
 
-	ctx := context.Background()
-	ctx = opentracing.ContextWithSpan(ctx, span)
+	ctx := opentracing.ContextWithSpan(req.Context(), span)
 
 	span.LogFields(
 		openlog.String("method", req.Method),
@@ -164,71 +325,40 @@ func allCantiHandler(w http.ResponseWriter, req *http.Request) {
 		openlog.String("host", req.Host),
 	)
 
-	canti, err := findAll(ctx, book)
+	book, err := parseBook(mux.Vars(req))
 	if err != nil {
-		//respondWithError(w, http.StatusInternalServerError, err.Error())
+		response.RespondWithError(w, http.StatusBadRequest, err.Error(), ctx)
 		return
 	}
-	response.RespondWithJson(w, http.StatusOK, canti, ctx)
-
-	}
-
-func findAll(ctx context.Context, book string) ([]models.Canto, error) {
-	span, _ := opentracing.StartSpanFromContext(ctx, "findAll")
-	defer span.Finish()
-	var canti []models.Canto
 
-	err := db.C(COLLECTION).Find(bson.M{"book": book}).All(&canti)
+	options, err := parseListOptions(req.URL.Query())
 	if err != nil {
-		span.LogFields(
-			openlog.String("mongoresult", "error getting canti"),
-		)
+		response.RespondWithError(w, http.StatusBadRequest, err.Error(), ctx)
+		return
 	}
 
-	response, _ := json.Marshal(canti)
-	span.LogFields(
-		openlog.String("mongoresult", string(response)),
-	)
-
-	return canti, err
-}
-
-func findAllWithQuery(ctx context.Context, query bson.M) ([]models.Canto) {
-	span, _ := opentracing.StartSpanFromContext(ctx, "findWithQuery")
-	defer span.Finish()
-	var canti []models.Canto
-
-	err := db.C(COLLECTION).Find(query).All(&canti)
+	canti, total, err := repo.FindAll(ctx, book, options)
 	if err != nil {
-		span.LogFields(
-			openlog.String("mongoresult", "error getting canti"),
-		)
+		respondWithStoreError(w, err, ctx)
+		return
 	}
 
-	response, _ := json.Marshal(canti)
-	span.LogFields(
-		openlog.String("mongoresult", string(response)),
-	)
-
-	return canti
+	setPagingHeaders(w, req, options, total)
+	response.RespondWithCacheableJson(w, req, options.project(canti), time.Time{}, ctx)
 }
 
-func findOneWithQuery(ctx context.Context, query bson.M) (models.Canto) {
-	span, _ := opentracing.StartSpanFromContext(ctx, "findWithQuery")
+func booksHandler(w http.ResponseWriter, req *http.Request) {
+	spanCtx, _ := opentracing.GlobalTracer().Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(req.Header))
+	span := opentracing.GlobalTracer().StartSpan("booksHandler", ext.RPCServerOption(spanCtx))
 	defer span.Finish()
-	var canto models.Canto
 
-	err := db.C(COLLECTION).Find(query).One(&canto)
-	if err != nil {
-		span.LogFields(
-			openlog.String("mongoresult", "error getting canti"),
-		)
-	}
+	ctx := opentracing.ContextWithSpan(req.Context(), span)
 
-	response, _ := json.Marshal(canto)
 	span.LogFields(
-		openlog.String("mongoresult", string(response)),
+		openlog.String("method", req.Method),
+		openlog.String("path", req.URL.Path),
+		openlog.String("host", req.Host),
 	)
 
-	return canto
-}
\ No newline at end of file
+	response.RespondWithCacheableJson(w, req, models.Books, time.Time{}, ctx)
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/joerivrij/microbases/shared/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func seedMemoryStore() {
+	repo = NewMemoryRepository(
+		models.Canto{Book: "Inferno", Roman: "I", Arabic: 1, Verse: 1, TextItalian: "Nel mezzo del cammin di nostra vita"},
+		models.Canto{Book: "Inferno", Roman: "I", Arabic: 1, Verse: 2, TextItalian: "mi ritrovai per una selva oscura,"},
+		models.Canto{Book: "Inferno", Roman: "I", Arabic: 1, Verse: 3, TextItalian: "ché la diritta via era smarrita."},
+	)
+	translations = NewMemoryTranslationRepository(defaultTranslation)
+	annotations = NewMemoryAnnotationRepository()
+	revisions = NewMemoryRevisionRepository()
+	invalidateConcordance()
+}
+
+func TestVerseHandlers(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		body     string
+		status   int
+		location string
+	}{
+		{"get verse", "GET", "/api/inferno/1/1", "", http.StatusOK, ""},
+		{"get missing verse", "GET", "/api/inferno/1/9", "", http.StatusNotFound, ""},
+		{"get invalid canto", "GET", "/api/inferno/99/1", "", http.StatusBadRequest, ""},
+		{"create verse", "POST", "/api/inferno/1/4", `{"textItalian": "Ahi quanto a dir qual era è cosa dura"}`, http.StatusCreated, "/api/inferno/1/4"},
+		{"create existing verse", "POST", "/api/inferno/1/1", `{"textItalian": "Nel mezzo"}`, http.StatusConflict, ""},
+		{"create without text", "POST", "/api/inferno/1/4", `{}`, http.StatusBadRequest, ""},
+		{"create body contradicting path", "POST", "/api/inferno/1/4", `{"verse": 5, "textItalian": "esta selva"}`, http.StatusBadRequest, ""},
+		{"create in unknown translation", "POST", "/api/inferno/1/4", `{"textItalian": "esta selva", "translations": {"nobody": "this wood"}}`, http.StatusBadRequest, ""},
+		{"create with legacy english text", "POST", "/api/inferno/1/4", `{"textItalian": "esta selva", "textEnglish": "this wood"}`, http.StatusCreated, "/api/inferno/1/4"},
+		{"replace verse", "PUT", "/api/inferno/1/1", `{"textItalian": "Nel mezzo del cammin"}`, http.StatusNoContent, ""},
+		{"replace missing verse", "PUT", "/api/inferno/1/9", `{"textItalian": "Nel mezzo del cammin"}`, http.StatusNotFound, ""},
+		{"patch verse", "PATCH", "/api/inferno/1/1", `{"words": 7}`, http.StatusNoContent, ""},
+		{"patch missing verse", "PATCH", "/api/inferno/1/9", `{"words": 7}`, http.StatusNotFound, ""},
+		{"patch nothing", "PATCH", "/api/inferno/1/1", `{}`, http.StatusBadRequest, ""},
+		{"patch unknown field", "PATCH", "/api/inferno/1/1", `{"verse": 2}`, http.StatusBadRequest, ""},
+		{"delete verse", "DELETE", "/api/inferno/1/1", "", http.StatusNoContent, ""},
+		{"delete missing verse", "DELETE", "/api/inferno/1/9", "", http.StatusNotFound, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			seedMemoryStore()
+
+			req := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+			rec := httptest.NewRecorder()
+			newRouter().ServeHTTP(rec, req)
+
+			if rec.Code != test.status {
+				t.Fatalf("expected status %d, got %d: %s", test.status, rec.Code, rec.Body.String())
+			}
+			if location := rec.Header().Get("Location"); location != test.location {
+				t.Errorf("expected Location %q, got %q", test.location, location)
+			}
+		})
+	}
+}
+
+func TestWritesAreVisible(t *testing.T) {
+	seedMemoryStore()
+	router := newRouter()
+
+	steps := []struct {
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"PATCH", "/api/inferno/1/2", `{"textItalian": "mi ritrovai per una selva"}`, http.StatusNoContent},
+		{"GET", "/api/inferno/1/2", "", http.StatusOK},
+		{"DELETE", "/api/inferno/1/2", "", http.StatusNoContent},
+		{"GET", "/api/inferno/1/2", "", http.StatusNotFound},
+		{"DELETE", "/api/inferno/1/2", "", http.StatusNotFound},
+	}
+
+	for _, step := range steps {
+		req := httptest.NewRequest(step.method, step.path, strings.NewReader(step.body))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		if rec.Code != step.status {
+			t.Fatalf("%s %s: expected status %d, got %d: %s", step.method, step.path, step.status, rec.Code, rec.Body.String())
+		}
+		if step.method == "GET" && step.status == http.StatusOK && !strings.Contains(rec.Body.String(), "per una selva") {
+			t.Errorf("expected the patched text, got %s", rec.Body.String())
+		}
+	}
+}
+
+func TestPagingHeaders(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		total string
+		links []string
+		verse string
+	}{
+		{"first page", "?limit=2", "3", []string{`offset=0`, `rel="first"`, `offset=2`, `rel="next"`, `rel="last"`}, `"verse":1`},
+		{"last page", "?limit=2&offset=2", "3", []string{`rel="first"`, `rel="prev"`, `rel="last"`}, `"verse":3`},
+		{"sorted descending", "?limit=1&sort=-verse", "3", []string{`rel="next"`}, `"verse":3`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			seedMemoryStore()
+
+			req := httptest.NewRequest("GET", "/api/inferno"+test.query, nil)
+			rec := httptest.NewRecorder()
+			newRouter().ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+			}
+			if total := rec.Header().Get("X-Total-Count"); total != test.total {
+				t.Errorf("expected X-Total-Count %s, got %s", test.total, total)
+			}
+			link := rec.Header().Get("Link")
+			for _, part := range test.links {
+				if !strings.Contains(link, part) {
+					t.Errorf("expected %s in Link %s", part, link)
+				}
+			}
+			if !strings.Contains(rec.Body.String(), test.verse) {
+				t.Errorf("expected %s in %s", test.verse, rec.Body.String())
+			}
+			if rec.Header().Get("Last-Modified") != "" {
+				t.Errorf("a list should not have a Last-Modified header")
+			}
+		})
+	}
+
+	t.Run("invalid limit", func(t *testing.T) {
+		seedMemoryStore()
+
+		req := httptest.NewRequest("GET", "/api/inferno?limit=0", nil)
+		rec := httptest.NewRecorder()
+		newRouter().ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("expected status 400, got %d", rec.Code)
+		}
+	})
+}
+
+func TestLegacyTextEnglish(t *testing.T) {
+	seedMemoryStore()
+	router := newRouter()
+
+	req := httptest.NewRequest("POST", "/api/inferno/1/4", strings.NewReader(`{"textItalian": "esta selva", "textEnglish": "this wood"}`))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	req = httptest.NewRequest("GET", "/api/inferno/1/4", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	body := rec.Body.String()
+	if !strings.Contains(body, `"textEnglish":"this wood"`) || !strings.Contains(body, `"translations":{"default":"this wood"}`) {
+		t.Errorf("expected the english text as textEnglish and as the default translation, got %s", body)
+	}
+}
+
+func TestAnnotationsNeedOauth(t *testing.T) {
+	seedMemoryStore()
+	OauthUrl = ""
+
+	req := httptest.NewRequest("POST", "/api/inferno/1/annotations", strings.NewReader(`{"from": 1, "to": 2, "text": "the dark wood"}`))
+	req.Header.Set("X-Author", "virgil")
+	rec := httptest.NewRecorder()
+	newRouter().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotImplemented {
+		t.Fatalf("expected status 501 without an oauth server, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestRevisions(t *testing.T) {
+	seedMemoryStore()
+	OauthUrl = ""
+	router := newRouter()
+
+	req := httptest.NewRequest("PATCH", "/api/inferno/1/2", strings.NewReader(`{"textItalian": "mi ritrovai per una selva"}`))
+	req.Header.Set("X-Author", "virgil")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected status 204, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/api/inferno/1/2/revisions", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var found []Revision
+	if err := json.Unmarshal(rec.Body.Bytes(), &found); err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0].Action != actionUpdate {
+		t.Fatalf("expected a single update, got %+v", found)
+	}
+	if found[0].Author != "anonymous" {
+		t.Errorf("expected the author to be anonymous without an oauth server, got %s", found[0].Author)
+	}
+
+	restore := "/api/inferno/1/2/revisions/" + found[0].ID.Hex() + "/restore"
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("POST", restore, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestTextEnglishInLists(t *testing.T) {
+	seedMemoryStore()
+	repo = NewMemoryRepository(
+		models.Canto{Book: "Inferno", Roman: "I", Arabic: 1, Verse: 1, Title: "Canto I", TextItalian: "Nel mezzo del cammin di nostra vita",
+			Translations: map[string]string{models.DefaultTranslation: "Midway upon the journey of our life"}},
+		models.Canto{Book: "Inferno", Roman: "I", Arabic: 1, Verse: 2, Title: "Canto I", TextItalian: "mi ritrovai per una selva oscura,",
+			Translations: map[string]string{models.DefaultTranslation: "I found myself within a forest dark,"}},
+	)
+
+	tests := []struct {
+		name  string
+		query string
+		first string
+	}{
+		{"projected", "?fields=textEnglish,title", `[{"textEnglish":"Midway upon the journey of our life","title":"Canto I"}`},
+		{"sorted", "?sort=textEnglish&fields=verse", `[{"verse":2}`},
+		{"sorted descending", "?sort=-textEnglish&fields=verse", `[{"verse":1}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			newRouter().ServeHTTP(rec, httptest.NewRequest("GET", "/api/inferno"+test.query, nil))
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+			}
+			if !strings.HasPrefix(rec.Body.String(), test.first) {
+				t.Errorf("expected the list to start with %s, got %s", test.first, rec.Body.String())
+			}
+		})
+	}
+}
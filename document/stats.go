@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"github.com/joerivrij/microbases/shared/models"
+	"github.com/joerivrij/microbases/shared/response"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	openlog "github.com/opentracing/opentracing-go/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"math"
+	"net/http"
+	"time"
+)
+
+// the aggregation reads every verse so it gets more time than a regular query
+const statsTimeout = 30 * time.Second
+
+// CorpusStats sums up the commedia and every cantica in it. Words of the original are the stored words of
+// every verse, a translation has no such field so its words are counted from its text
+type CorpusStats struct {
+	Translation        string      `json:"translation,omitempty"`
+	Verses             int         `json:"verses"`
+	Words              int         `json:"words"`
+	AverageVerseLength float64     `json:"averageVerseLength"`
+	Vocabulary         int         `json:"vocabulary"`
+	Books              []BookStats `json:"books"`
+}
+
+type BookStats struct {
+	Book               string       `json:"book"`
+	Cantos             int          `json:"cantos"`
+	Verses             int          `json:"verses"`
+	Words              int          `json:"words"`
+	AverageVerseLength float64      `json:"averageVerseLength"`
+	Vocabulary         int          `json:"vocabulary"`
+	Longest            CantoStats   `json:"longest"`
+	Shortest           CantoStats   `json:"shortest"`
+	Canti              []CantoStats `json:"canti"`
+}
+
+type CantoStats struct {
+	Canto              int     `json:"canto"`
+	Verses             int     `json:"verses"`
+	Words              int     `json:"words"`
+	AverageVerseLength float64 `json:"averageVerseLength"`
+}
+
+// statsResult is what comes back from the aggregation, the rest is derived from it
+type statsResult struct {
+	Canti []struct {
+		ID struct {
+			Book  string `bson:"book"`
+			Canto int    `bson:"canto"`
+		} `bson:"_id"`
+		Verses int `bson:"verses"`
+		Words  int `bson:"words"`
+	} `bson:"canti"`
+	Vocabulary []struct {
+		Book string `bson:"_id"`
+		Size int    `bson:"size"`
+	} `bson:"vocabulary"`
+	Total []struct {
+		Size int `bson:"size"`
+	} `bson:"total"`
+}
+
+func statsHandler(w http.ResponseWriter, req *http.Request) {
+	spanCtx, _ := opentracing.GlobalTracer().Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(req.Header))
+	span := opentracing.GlobalTracer().StartSpan("statsHandler", ext.RPCServerOption(spanCtx))
+	defer span.Finish()
+
+	ctx := opentracing.ContextWithSpan(req.Context(), span)
+
+	span.LogFields(
+		openlog.String("method", req.Method),
+		openlog.String("path", req.URL.Path),
+		openlog.String("host", req.Host),
+	)
+
+	if !requireMongo(w, ctx) {
+		return
+	}
+
+	source, err := parseTextSource(req.URL.Query())
+	if err != nil {
+		response.RespondWithError(w, http.StatusBadRequest, err.Error(), ctx)
+		return
+	}
+
+	result, err := aggregateStats(ctx, source)
+	if err != nil {
+		respondWithStoreError(w, err, ctx)
+		return
+	}
+
+	stats := corpusStats(result)
+	stats.Translation = source
+
+	response.RespondWithCacheableJson(w, req, stats, time.Time{}, ctx)
+}
+
+// aggregateStats counts the verses and words of every canto and the distinct words of every book and of the
+// whole commedia in a single pass. The vocabulary is always taken from the text, where a word is a run of
+// letters like the tokenizer has it, so an elision like dell'alta counts as two
+func aggregateStats(ctx context.Context, source string) (statsResult, error) {
+	span, _ := opentracing.StartSpanFromContext(ctx, "aggregateStats")
+	defer span.Finish()
+
+	queryCtx, cancel := context.WithTimeout(ctx, statsTimeout)
+	defer cancel()
+
+	tokens := bson.M{"$map": bson.M{
+		"input": bson.M{"$regexFindAll": bson.M{
+			"input": bson.M{"$toLower": textExpression(source)},
+			"regex": `\p{L}+`,
+		}},
+		"as": "found",
+		"in": "$$found.match",
+	}}
+
+	count := interface{}("$words")
+	if source != original {
+		count = bson.M{"$size": "$tokens"}
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$project", Value: bson.M{"book": 1, "arabic": 1, "tokens": tokens, "count": count}}},
+		{{Key: "$facet", Value: bson.M{
+			"canti": bson.A{
+				bson.M{"$group": bson.M{
+					"_id":    bson.M{"book": "$book", "canto": "$arabic"},
+					"verses": bson.M{"$sum": 1},
+					"words":  bson.M{"$sum": "$count"},
+				}},
+				bson.M{"$sort": bson.M{"_id.canto": 1}},
+			},
+			"vocabulary": bson.A{
+				bson.M{"$unwind": "$tokens"},
+				bson.M{"$group": bson.M{"_id": bson.M{"book": "$book", "word": "$tokens"}}},
+				bson.M{"$group": bson.M{"_id": "$_id.book", "size": bson.M{"$sum": 1}}},
+			},
+			"total": bson.A{
+				bson.M{"$unwind": "$tokens"},
+				bson.M{"$group": bson.M{"_id": "$tokens"}},
+				bson.M{"$count": "size"},
+			},
+		}}},
+	}
+
+	var result statsResult
+	cursor, err := db.Collection(COLLECTION).Aggregate(queryCtx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		span.LogFields(
+			openlog.String("mongoresult", "error aggregating stats"),
+			openlog.Error(err),
+		)
+		return result, err
+	}
+	defer cursor.Close(queryCtx)
+
+	if cursor.Next(queryCtx) {
+		err = cursor.Decode(&result)
+	} else {
+		err = cursor.Err()
+	}
+	if err != nil {
+		span.LogFields(
+			openlog.String("mongoresult", "error reading stats"),
+			openlog.Error(err),
+		)
+		return result, err
+	}
+
+	span.LogFields(
+		openlog.Int("mongoresult", len(result.Canti)),
+	)
+	return result, nil
+}
+
+// corpusStats puts the aggregated canti together per book in catalog order
+func corpusStats(result statsResult) CorpusStats {
+	stats := CorpusStats{Books: []BookStats{}}
+	if len(result.Total) > 0 {
+		stats.Vocabulary = result.Total[0].Size
+	}
+
+	vocabulary := map[string]int{}
+	for _, book := range result.Vocabulary {
+		vocabulary[book.Book] = book.Size
+	}
+
+	for _, book := range models.Books {
+		bookStats := BookStats{Book: book.Name, Vocabulary: vocabulary[book.Name], Canti: []CantoStats{}}
+		for _, canto := range result.Canti {
+			if canto.ID.Book != book.Name {
+				continue
+			}
+
+			cantoStats := CantoStats{
+				Canto:              canto.ID.Canto,
+				Verses:             canto.Verses,
+				Words:              canto.Words,
+				AverageVerseLength: average(canto.Words, canto.Verses),
+			}
+			bookStats.Canti = append(bookStats.Canti, cantoStats)
+			bookStats.Verses += canto.Verses
+			bookStats.Words += canto.Words
+
+			if len(bookStats.Canti) == 1 || cantoStats.Words > bookStats.Longest.Words {
+				bookStats.Longest = cantoStats
+			}
+			if len(bookStats.Canti) == 1 || cantoStats.Words < bookStats.Shortest.Words {
+				bookStats.Shortest = cantoStats
+			}
+		}
+		if len(bookStats.Canti) == 0 {
+			continue
+		}
+
+		bookStats.Cantos = len(bookStats.Canti)
+		bookStats.AverageVerseLength = average(bookStats.Words, bookStats.Verses)
+		stats.Books = append(stats.Books, bookStats)
+		stats.Verses += bookStats.Verses
+		stats.Words += bookStats.Words
+	}
+	stats.AverageVerseLength = average(stats.Words, stats.Verses)
+
+	return stats
+}
+
+// average returns the words per verse rounded to two decimals
+func average(words int, verses int) float64 {
+	if verses == 0 {
+		return 0
+	}
+	return math.Round(float64(words)/float64(verses)*100) / 100
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"testing"
+)
+
+func TestCorpusStats(t *testing.T) {
+	// the aggregation result is built from bson like mongo hands it back
+	data, _ := bson.Marshal(bson.M{
+		"canti": bson.A{
+			bson.M{"_id": bson.M{"book": "Purgatorio", "canto": 1}, "verses": 4, "words": 10},
+			bson.M{"_id": bson.M{"book": "Inferno", "canto": 1}, "verses": 3, "words": 20},
+			bson.M{"_id": bson.M{"book": "Inferno", "canto": 2}, "verses": 2, "words": 30},
+		},
+		"vocabulary": bson.A{
+			bson.M{"_id": "Inferno", "size": 25},
+			bson.M{"_id": "Purgatorio", "size": 8},
+		},
+		"total": bson.A{bson.M{"size": 30}},
+	})
+	var result statsResult
+	if err := bson.Unmarshal(data, &result); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := corpusStats(result)
+
+	if stats.Verses != 9 || stats.Words != 60 || stats.AverageVerseLength != 6.67 || stats.Vocabulary != 30 {
+		t.Errorf("expected 9 verses, 60 words, 6.67 words per verse and 30 distinct words, got %+v", stats)
+	}
+	if len(stats.Books) != 2 || stats.Books[0].Book != "Inferno" || stats.Books[1].Book != "Purgatorio" {
+		t.Fatalf("expected the books in catalog order, got %+v", stats.Books)
+	}
+
+	inferno := stats.Books[0]
+	if inferno.Cantos != 2 || inferno.Verses != 5 || inferno.Words != 50 || inferno.AverageVerseLength != 10 || inferno.Vocabulary != 25 {
+		t.Errorf("unexpected inferno stats %+v", inferno)
+	}
+	if inferno.Longest.Canto != 2 || inferno.Longest.AverageVerseLength != 15 {
+		t.Errorf("expected canto 2 to be the longest, got %+v", inferno.Longest)
+	}
+	if inferno.Shortest.Canto != 1 || inferno.Shortest.AverageVerseLength != 6.67 {
+		t.Errorf("expected canto 1 to be the shortest, got %+v", inferno.Shortest)
+	}
+
+	purgatorio := stats.Books[1]
+	if purgatorio.Longest.Canto != 1 || purgatorio.Shortest.Canto != 1 || purgatorio.AverageVerseLength != 2.5 {
+		t.Errorf("expected the only canto to be the longest and the shortest, got %+v", purgatorio)
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/joerivrij/microbases/shared/models"
+	"github.com/joerivrij/microbases/shared/response"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	openlog "github.com/opentracing/opentracing-go/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"net/http"
+	"net/url"
+	"sort"
+)
+
+const TRANSLATIONS = "translations"
+
+// original stands for the italian text where a translation id is expected
+const original = ""
+
+// VerseTranslation is a verse in a single translation next to the original
+type VerseTranslation struct {
+	Book        string             `json:"book"`
+	Canto       int                `json:"canto"`
+	Verse       int                `json:"verse"`
+	TextItalian string             `json:"textItalian"`
+	Translation models.Translation `json:"translation"`
+	Text        string             `json:"text"`
+}
+
+func translationsHandler(w http.ResponseWriter, req *http.Request) {
+	spanCtx, _ := opentracing.GlobalTracer().Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(req.Header))
+	span := opentracing.GlobalTracer().StartSpan("translationsHandler", ext.RPCServerOption(spanCtx))
+	defer span.Finish()
+
+	ctx := opentracing.ContextWithSpan(req.Context(), span)
+
+	span.LogFields(
+		openlog.String("method", req.Method),
+		openlog.String("path", req.URL.Path),
+		openlog.String("host", req.Host),
+	)
+
+	found, err := translations.FindAll(ctx)
+	if err != nil {
+		respondWithStoreError(w, err, ctx)
+		return
+	}
+
+	response.RespondWithJson(w, http.StatusOK, found, ctx)
+}
+
+func translationHandler(w http.ResponseWriter, req *http.Request) {
+	spanCtx, _ := opentracing.GlobalTracer().Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(req.Header))
+	span := opentracing.GlobalTracer().StartSpan("translationHandler", ext.RPCServerOption(spanCtx))
+	defer span.Finish()
+
+	ctx := opentracing.ContextWithSpan(req.Context(), span)
+
+	span.LogFields(
+		openlog.String("method", req.Method),
+		openlog.String("path", req.URL.Path),
+		openlog.String("host", req.Host),
+	)
+
+	translation, err := translations.Find(ctx, mux.Vars(req)["translation"])
+	if err == ErrNotFound {
+		response.RespondWithError(w, http.StatusNotFound, "translation not found", ctx)
+		return
+	}
+	if err != nil {
+		respondWithStoreError(w, err, ctx)
+		return
+	}
+
+	response.RespondWithJson(w, http.StatusOK, translation, ctx)
+}
+
+// saveTranslationHandler adds a translation or changes its metadata, the text is set on the verses
+func saveTranslationHandler(w http.ResponseWriter, req *http.Request) {
+	spanCtx, _ := opentracing.GlobalTracer().Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(req.Header))
+	span := opentracing.GlobalTracer().StartSpan("saveTranslationHandler", ext.RPCServerOption(spanCtx))
+	defer span.Finish()
+
+	ctx := opentracing.ContextWithSpan(req.Context(), span)
+
+	span.LogFields(
+		openlog.String("method", req.Method),
+		openlog.String("path", req.URL.Path),
+		openlog.String("host", req.Host),
+	)
+
+	var translation models.Translation
+	if err := json.NewDecoder(req.Body).Decode(&translation); err != nil {
+		response.RespondWithError(w, http.StatusBadRequest, "invalid body: "+err.Error(), ctx)
+		return
+	}
+
+	id := mux.Vars(req)["translation"]
+	if translation.ID != "" && translation.ID != id {
+		response.RespondWithError(w, http.StatusBadRequest, "id in body does not match the path", ctx)
+		return
+	}
+	translation.ID = id
+	if err := translation.Validate(); err != nil {
+		response.RespondWithError(w, http.StatusBadRequest, err.Error(), ctx)
+		return
+	}
+
+	if err := translations.Save(ctx, translation); err != nil {
+		respondWithStoreError(w, err, ctx)
+		return
+	}
+
+	response.RespondWithJson(w, http.StatusOK, translation, ctx)
+}
+
+func verseTranslationHandler(w http.ResponseWriter, req *http.Request) {
+	spanCtx, _ := opentracing.GlobalTracer().Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(req.Header))
+	span := opentracing.GlobalTracer().StartSpan("verseTranslationHandler", ext.RPCServerOption(spanCtx))
+	defer span.Finish()
+
+	ctx := opentracing.ContextWithSpan(req.Context(), span)
+
+	span.LogFields(
+		openlog.String("method", req.Method),
+		openlog.String("path", req.URL.Path),
+		openlog.String("host", req.Host),
+	)
+
+	vars := mux.Vars(req)
+	book, arabic, verse, err := parseVerseVars(vars)
+	if err != nil {
+		response.RespondWithError(w, http.StatusBadRequest, err.Error(), ctx)
+		return
+	}
+
+	translation, err := translations.Find(ctx, vars["translation"])
+	if err == ErrNotFound {
+		response.RespondWithError(w, http.StatusNotFound, "translation not found", ctx)
+		return
+	}
+	if err != nil {
+		respondWithStoreError(w, err, ctx)
+		return
+	}
+
+	canto, err := repo.FindVerse(ctx, book, arabic, verse)
+	if err == ErrNotFound {
+		response.RespondWithError(w, http.StatusNotFound, "verse not found", ctx)
+		return
+	}
+	if err != nil {
+		respondWithStoreError(w, err, ctx)
+		return
+	}
+
+	text, ok := canto.Translation(translation.ID)
+	if !ok {
+		message := fmt.Sprintf("%s %d:%d is not in translation %s", book, arabic, verse, translation.ID)
+		response.RespondWithError(w, http.StatusNotFound, message, ctx)
+		return
+	}
+
+	result := VerseTranslation{
+		Book:        canto.Book,
+		Canto:       canto.Arabic,
+		Verse:       canto.Verse,
+		TextItalian: canto.TextItalian,
+		Translation: translation,
+		Text:        text,
+	}
+	response.RespondWithCacheableJson(w, req, result, canto.Modified, ctx)
+}
+
+// parseTextSource picks the text a request is about. lang=it, the default, is the original, lang=en is
+// kept from before there were several translations and means the default one, translation=<id> picks any
+func parseTextSource(values url.Values) (string, error) {
+	if id := values.Get("translation"); id != "" {
+		if !models.ValidTranslationID(id) {
+			return original, fmt.Errorf("translation %q is not a valid id", id)
+		}
+		return id, nil
+	}
+
+	switch values.Get("lang") {
+	case "", "it":
+		return original, nil
+	case "en":
+		return models.DefaultTranslation, nil
+	}
+	return original, errors.New("lang has to be it or en")
+}
+
+// textField is the stored field holding the text of a source
+func textField(source string) string {
+	if source == original {
+		return "textItalian"
+	}
+	return "translations." + source
+}
+
+// textExpression is the text of a source in an aggregation, the default translation falls back to the
+// textEnglish of canti the migrate command has not moved yet
+func textExpression(source string) bson.M {
+	if source == models.DefaultTranslation {
+		return bson.M{"$ifNull": bson.A{"$" + textField(source), bson.M{"$ifNull": bson.A{"$textEnglish", ""}}}}
+	}
+	return bson.M{"$ifNull": bson.A{"$" + textField(source), ""}}
+}
+
+// textFilter matches the text of a source against condition, see textExpression
+func textFilter(source string, condition interface{}) bson.M {
+	if source == models.DefaultTranslation {
+		return bson.M{"$or": bson.A{bson.M{textField(source): condition}, bson.M{"textEnglish": condition}}}
+	}
+	return bson.M{textField(source): condition}
+}
+
+func textOf(canto models.Canto, source string) string {
+	if source == original {
+		return canto.TextItalian
+	}
+	text, _ := canto.Translation(source)
+	return text
+}
+
+// unknownTranslations returns the ids a verse is written with that have no translation yet,
+// new translations have to be added with their metadata before verses can use them
+func unknownTranslations(ctx context.Context, ids []string) ([]string, error) {
+	var unknown []string
+	for _, id := range ids {
+		_, err := translations.Find(ctx, id)
+		if err == ErrNotFound {
+			unknown = append(unknown, id)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(unknown)
+	return unknown, nil
+}
+
+// defaultTranslation is the metadata of the english text the canti were first loaded with
+var defaultTranslation = models.Translation{
+	ID:       models.DefaultTranslation,
+	Language: "en",
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/joerivrij/microbases/shared/models"
+	"github.com/joerivrij/microbases/shared/response"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	openlog "github.com/opentracing/opentracing-go/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"strings"
+)
+
+// CantoPatch holds the fields of a canto that can be changed with a PATCH,
+// fields that are left out of the body are left untouched
+type CantoPatch struct {
+	Title       *string `json:"title"`
+	Roman       *string `json:"roman"`
+	Words       *int    `json:"words"`
+	TextItalian *string `json:"textItalian"`
+	// Translations sets the text of the given translations, a null removes the translation from the verse
+	Translations map[string]*string `json:"translations"`
+}
+
+func createCantoHandler(w http.ResponseWriter, req *http.Request) {
+	spanCtx, _ := opentracing.GlobalTracer().Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(req.Header))
+	span := opentracing.GlobalTracer().StartSpan("createCantoHandler", ext.RPCServerOption(spanCtx))
+	defer span.Finish()
+
+	ctx := opentracing.ContextWithSpan(req.Context(), span)
+
+	span.LogFields(
+		openlog.String("method", req.Method),
+		openlog.String("path", req.URL.Path),
+		openlog.String("host", req.Host),
+	)
+
+	canto, err := decodeCanto(req)
+	if err != nil {
+		response.RespondWithError(w, http.StatusBadRequest, err.Error(), ctx)
+		return
+	}
+
+	if !checkTranslations(w, ctx, translationIDs(canto.Translations)) {
+		return
+	}
+
+	canto.ID = primitive.NewObjectID()
+	err = inTransaction(ctx, func(ctx context.Context) error {
+		if err := repo.Insert(ctx, canto); err != nil {
+			return err
+		}
+		return recordRevision(ctx, req, actionCreate, models.Canto{}, &canto)
+	})
+	if err == ErrDuplicate {
+		message := fmt.Sprintf("%s %d:%d already exists", canto.Book, canto.Arabic, canto.Verse)
+		response.RespondWithError(w, http.StatusConflict, message, ctx)
+		return
+	}
+	if err != nil {
+		respondWithStoreError(w, err, ctx)
+		return
+	}
+
+	invalidateConcordance()
+
+	w.Header().Set("Location", req.URL.Path)
+	response.RespondWithJson(w, http.StatusCreated, canto, ctx)
+}
+
+func replaceCantoHandler(w http.ResponseWriter, req *http.Request) {
+	spanCtx, _ := opentracing.GlobalTracer().Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(req.Header))
+	span := opentracing.GlobalTracer().StartSpan("replaceCantoHandler", ext.RPCServerOption(spanCtx))
+	defer span.Finish()
+
+	ctx := opentracing.ContextWithSpan(req.Context(), span)
+
+	span.LogFields(
+		openlog.String("method", req.Method),
+		openlog.String("path", req.URL.Path),
+		openlog.String("host", req.Host),
+	)
+
+	canto, err := decodeCanto(req)
+	if err != nil {
+		response.RespondWithError(w, http.StatusBadRequest, err.Error(), ctx)
+		return
+	}
+
+	if !checkTranslations(w, ctx, translationIDs(canto.Translations)) {
+		return
+	}
+
+	update := cantoValues(canto)
+
+	err = inTransaction(ctx, func(ctx context.Context) error {
+		before, err := repo.Update(ctx, canto.Book, canto.Arabic, canto.Verse, update)
+		if err != nil {
+			return err
+		}
+		after := applyValues(before, update)
+		return recordRevision(ctx, req, actionUpdate, before, &after)
+	})
+	if err == ErrNotFound {
+		response.RespondWithError(w, http.StatusNotFound, "verse not found", ctx)
+		return
+	}
+	if err != nil {
+		respondWithStoreError(w, err, ctx)
+		return
+	}
+
+	invalidateConcordance()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func patchCantoHandler(w http.ResponseWriter, req *http.Request) {
+	spanCtx, _ := opentracing.GlobalTracer().Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(req.Header))
+	span := opentracing.GlobalTracer().StartSpan("patchCantoHandler", ext.RPCServerOption(spanCtx))
+	defer span.Finish()
+
+	ctx := opentracing.ContextWithSpan(req.Context(), span)
+
+	span.LogFields(
+		openlog.String("method", req.Method),
+		openlog.String("path", req.URL.Path),
+		openlog.String("host", req.Host),
+	)
+
+	book, arabic, verse, err := parseVerseVars(mux.Vars(req))
+	if err != nil {
+		response.RespondWithError(w, http.StatusBadRequest, err.Error(), ctx)
+		return
+	}
+
+	var patch CantoPatch
+	decoder := json.NewDecoder(req.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&patch); err != nil {
+		response.RespondWithError(w, http.StatusBadRequest, "invalid body: "+err.Error(), ctx)
+		return
+	}
+
+	update, err := patch.toUpdate()
+	if err != nil {
+		response.RespondWithError(w, http.StatusBadRequest, err.Error(), ctx)
+		return
+	}
+
+	var added []string
+	for id, text := range patch.Translations {
+		if text != nil {
+			added = append(added, id)
+		}
+	}
+	if !checkTranslations(w, ctx, added) {
+		return
+	}
+
+	err = inTransaction(ctx, func(ctx context.Context) error {
+		before, err := repo.Update(ctx, book, arabic, verse, update)
+		if err != nil {
+			return err
+		}
+		after := applyValues(before, update)
+		return recordRevision(ctx, req, actionUpdate, before, &after)
+	})
+	if err == ErrNotFound {
+		response.RespondWithError(w, http.StatusNotFound, "verse not found", ctx)
+		return
+	}
+	if err != nil {
+		respondWithStoreError(w, err, ctx)
+		return
+	}
+
+	invalidateConcordance()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func deleteCantoHandler(w http.ResponseWriter, req *http.Request) {
+	spanCtx, _ := opentracing.GlobalTracer().Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(req.Header))
+	span := opentracing.GlobalTracer().StartSpan("deleteCantoHandler", ext.RPCServerOption(spanCtx))
+	defer span.Finish()
+
+	ctx := opentracing.ContextWithSpan(req.Context(), span)
+
+	span.LogFields(
+		openlog.String("method", req.Method),
+		openlog.String("path", req.URL.Path),
+		openlog.String("host", req.Host),
+	)
+
+	book, arabic, verse, err := parseVerseVars(mux.Vars(req))
+	if err != nil {
+		response.RespondWithError(w, http.StatusBadRequest, err.Error(), ctx)
+		return
+	}
+
+	err = inTransaction(ctx, func(ctx context.Context) error {
+		before, err := repo.Delete(ctx, book, arabic, verse)
+		if err != nil {
+			return err
+		}
+		return recordRevision(ctx, req, actionDelete, before, nil)
+	})
+	if err == ErrNotFound {
+		response.RespondWithError(w, http.StatusNotFound, "verse not found", ctx)
+		return
+	}
+	if err != nil {
+		respondWithStoreError(w, err, ctx)
+		return
+	}
+
+	invalidateConcordance()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// decodeCanto reads a canto from the body, the book, canto and verse in the path
+// are leading and the body is not allowed to contradict them
+func decodeCanto(req *http.Request) (models.Canto, error) {
+	var canto models.Canto
+
+	book, arabic, verse, err := parseVerseVars(mux.Vars(req))
+	if err != nil {
+		return canto, err
+	}
+
+	if err := json.NewDecoder(req.Body).Decode(&canto); err != nil {
+		return canto, errors.New("invalid body: " + err.Error())
+	}
+
+	if canto.Book != "" {
+		if found, ok := models.LookupBook(canto.Book); !ok || found.Name != book {
+			return canto, errors.New("book in body does not match the path")
+		}
+	}
+	if canto.Arabic != 0 && canto.Arabic != arabic {
+		return canto, errors.New("arabic in body does not match the path")
+	}
+	if canto.Verse != 0 && canto.Verse != verse {
+		return canto, errors.New("verse in body does not match the path")
+	}
+
+	canto.Book = book
+	canto.Arabic = arabic
+	canto.Verse = verse
+
+	return canto, canto.Validate()
+}
+
+func (p CantoPatch) toUpdate() (map[string]interface{}, error) {
+	update := map[string]interface{}{}
+	if p.Title != nil {
+		update["title"] = *p.Title
+	}
+	if p.Roman != nil {
+		update["roman"] = *p.Roman
+	}
+	if p.Words != nil {
+		if *p.Words < 0 {
+			return nil, errors.New("words cannot be negative")
+		}
+		update["words"] = *p.Words
+	}
+	if p.TextItalian != nil {
+		if *p.TextItalian == "" {
+			return nil, errors.New("textItalian cannot be empty")
+		}
+		update["textItalian"] = *p.TextItalian
+	}
+	for id, text := range p.Translations {
+		if !models.ValidTranslationID(id) {
+			return nil, fmt.Errorf("translation id %q is not valid", id)
+		}
+		if text == nil {
+			update["translations."+id] = nil
+			continue
+		}
+		update["translations."+id] = *text
+	}
+
+	if len(update) == 0 {
+		return nil, errors.New("nothing to update")
+	}
+	return update, nil
+}
+
+// checkTranslations answers with a 400 when a verse is written in a translation that does not exist
+func checkTranslations(w http.ResponseWriter, ctx context.Context, ids []string) bool {
+	unknown, err := unknownTranslations(ctx, ids)
+	if err != nil {
+		respondWithStoreError(w, err, ctx)
+		return false
+	}
+	if len(unknown) > 0 {
+		message := fmt.Sprintf("unknown translation %s, add it under /api/translations first", strings.Join(unknown, ", "))
+		response.RespondWithError(w, http.StatusBadRequest, message, ctx)
+		return false
+	}
+	return true
+}
+
+func translationIDs(translations map[string]string) []string {
+	ids := make([]string, 0, len(translations))
+	for id := range translations {
+		ids = append(ids, id)
+	}
+	return ids
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/joerivrij/microbases/shared/response"
+	"github.com/joerivrij/microbases/shared/tokenizer"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	openlog "github.com/opentracing/opentracing-go/log"
+	"net/http"
+)
+
+const maxPatchWords = 500
+
+// PatchBody changes the counts of single words, {"words": {"vita": 1, "selva": -1}}
+type PatchBody struct {
+	Words map[string]int `json:"words"`
+}
+
+func deleteHandler(w http.ResponseWriter, req *http.Request) {
+	spanCtx, _ := opentracing.GlobalTracer().Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(req.Header))
+	span := opentracing.GlobalTracer().StartSpan("deleteHandler", ext.RPCServerOption(spanCtx))
+	defer span.Finish()
+
+	ctx := opentracing.ContextWithSpan(req.Context(), span)
+
+	span.LogFields(
+		openlog.String("method", req.Method),
+		openlog.String("path", req.URL.Path),
+		openlog.String("host", req.Host),
+	)
+
+	book, canto, verse, err := parseVerseVars(mux.Vars(req))
+	if err != nil {
+		response.RespondWithError(w, http.StatusBadRequest, err.Error(), ctx)
+		return
+	}
+
+	// replacing the counts with nothing also takes the verse off the rankings, the next GET counts it again
+	err = store.Replace(ctx, book, canto, verse, nil, 0)
+	if err != nil {
+		respondWithStoreError(w, err, ctx)
+		return
+	}
+
+	audit(ctx, req, "delete", verseKey(book, canto, verse), nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func patchHandler(w http.ResponseWriter, req *http.Request) {
+	spanCtx, _ := opentracing.GlobalTracer().Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(req.Header))
+	span := opentracing.GlobalTracer().StartSpan("patchHandler", ext.RPCServerOption(spanCtx))
+	defer span.Finish()
+
+	ctx := opentracing.ContextWithSpan(req.Context(), span)
+
+	span.LogFields(
+		openlog.String("method", req.Method),
+		openlog.String("path", req.URL.Path),
+		openlog.String("host", req.Host),
+	)
+
+	book, canto, verse, err := parseVerseVars(mux.Vars(req))
+	if err != nil {
+		response.RespondWithError(w, http.StatusBadRequest, err.Error(), ctx)
+		return
+	}
+
+	var patch PatchBody
+	decoder := json.NewDecoder(req.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&patch); err != nil {
+		response.RespondWithError(w, http.StatusBadRequest, "invalid body: "+err.Error(), ctx)
+		return
+	}
+	if err := patch.validate(); err != nil {
+		response.RespondWithError(w, http.StatusBadRequest, err.Error(), ctx)
+		return
+	}
+
+	counts, err := store.Increment(ctx, book, canto, verse, patch.Words)
+	if err == ErrNotCounted {
+		response.RespondWithError(w, http.StatusNotFound, "the verse has not been counted yet, GET it first", ctx)
+		return
+	}
+	if errors.Is(err, ErrNegativeCount) {
+		response.RespondWithError(w, http.StatusConflict, err.Error(), ctx)
+		return
+	}
+	if err != nil {
+		respondWithStoreError(w, err, ctx)
+		return
+	}
+
+	audit(ctx, req, "patch", verseKey(book, canto, verse), patch.Words)
+	respondWithJson(w, http.StatusOK, applyTokenOptions(counts, tokenizer.Options{}), ctx)
+}
+
+// validate only accepts words the way they are stored, so a patch cannot add a word a count never has
+func (p PatchBody) validate() error {
+	if len(p.Words) == 0 {
+		return errors.New("words cannot be empty")
+	}
+	if len(p.Words) > maxPatchWords {
+		return fmt.Errorf("at most %d words can be changed at once", maxPatchWords)
+	}
+	for word, by := range p.Words {
+		tokens := tokenizer.Tokenize(word, tokenizer.Options{})
+		if len(tokens) != 1 || tokens[0] != word {
+			return fmt.Errorf("%q is not a single lowercase word", word)
+		}
+		if by == 0 {
+			return fmt.Errorf("the change of %q cannot be 0", word)
+		}
+	}
+	return nil
+}
+
+// audit records a change made by hand to the word counts in a span of its own, so the changes can be
+// found in jaeger by the operation auditWordCount
+func audit(ctx context.Context, req *http.Request, action string, key string, changes map[string]int) {
+	span, _ := opentracing.StartSpanFromContext(ctx, "auditWordCount")
+	defer span.Finish()
+
+	span.SetTag("audit.action", action)
+	span.SetTag("audit.key", key)
+
+	body, _ := json.Marshal(changes)
+	span.LogFields(
+		openlog.String("event", "audit"),
+		openlog.String("action", action),
+		openlog.String("key", key),
+		openlog.String("changes", string(body)),
+		openlog.String("remote", req.RemoteAddr),
+		openlog.String("user_agent", req.UserAgent()),
+	)
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"context"
+	"errors"
+	microclient "github.com/joerivrij/microbases/shared/client"
+	"github.com/joerivrij/microbases/shared/response"
+	"net/http"
+	"net/url"
+)
+
+// respondWithStoreError maps an error of the store or of the call to the document service to the status the client should see
+func respondWithStoreError(w http.ResponseWriter, err error, ctx context.Context) {
+	var statusErr *microclient.StatusError
+	var urlErr *url.Error
+	switch {
+	case errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound:
+		response.RespondWithError(w, http.StatusNotFound, "verse not found", ctx)
+	case errors.As(err, &statusErr) || errors.As(err, &urlErr):
+		response.RespondWithError(w, http.StatusBadGateway, "the document service failed: "+err.Error(), ctx)
+	default:
+		response.RespondWithError(w, http.StatusServiceUnavailable, "the word count store is not available: "+err.Error(), ctx)
+	}
+}
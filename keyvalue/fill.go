@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/joerivrij/microbases/shared/tokenizer"
+	"github.com/opentracing/opentracing-go"
+	openlog "github.com/opentracing/opentracing-go/log"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// fillLockTimeout is how long a replica may hold the lock on a verse, a fill that takes longer loses it
+	fillLockTimeout  = 10 * time.Second
+	fillPollInterval = 50 * time.Millisecond
+)
+
+var (
+	// WordCountTTL is how long the counts of a verse are kept before they are fetched again, 0 keeps them forever
+	WordCountTTL = 24 * time.Hour
+	// WordCountRefresh refills a verse in the background when it is read this close to expiring, 0 turns it off
+	WordCountRefresh time.Duration
+)
+
+// fills makes sure one verse is filled once per replica at a time, concurrent requests wait for the running fill
+var fills = struct {
+	sync.Mutex
+	calls map[string]*fillCall
+}{calls: map[string]*fillCall{}}
+
+type fillCall struct {
+	done chan struct{}
+	err  error
+}
+
+func loadCacheConfig() {
+	if value := os.Getenv("WORDCOUNT_TTL"); value != "" {
+		ttl, err := time.ParseDuration(value)
+		if err != nil || ttl < 0 {
+			panic("WORDCOUNT_TTL has to be a duration like 24h")
+		}
+		WordCountTTL = ttl
+	}
+	if value := os.Getenv("WORDCOUNT_REFRESH"); value != "" {
+		refresh, err := time.ParseDuration(value)
+		if err != nil || refresh < 0 {
+			panic("WORDCOUNT_REFRESH has to be a duration like 1h")
+		}
+		WordCountRefresh = refresh
+	}
+}
+
+// fillOnce runs fill for a key unless it is already running, in that case it waits for that fill to finish
+func fillOnce(key string, fill func() error) error {
+	fills.Lock()
+	if call, ok := fills.calls[key]; ok {
+		fills.Unlock()
+		<-call.done
+		return call.err
+	}
+	call := &fillCall{done: make(chan struct{})}
+	fills.calls[key] = call
+	fills.Unlock()
+
+	call.err = fill()
+	close(call.done)
+
+	fills.Lock()
+	delete(fills.calls, key)
+	fills.Unlock()
+	return call.err
+}
+
+// fillVerse counts the words of a verse while holding the lock of the verse in the store, so only one replica
+// calls the document service. A replica that does not get the lock waits for the counts to show up.
+// A refresh replaces counts that are still there and gives up when another replica is already filling
+func fillVerse(book string, canto int, verse int, refresh bool, ctx context.Context) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "fillVerse")
+	defer span.Finish()
+
+	key := verseKey(book, canto, verse)
+	deadline := time.Now().Add(fillLockTimeout)
+	for {
+		unlock, ok, err := store.Lock(ctx, book, canto, verse, fillLockTimeout)
+		if err != nil {
+			return err
+		}
+		if ok {
+			defer unlock()
+			break
+		}
+		if refresh {
+			span.LogFields(openlog.String("event", "another replica is filling "+key))
+			return nil
+		}
+		exists, err := store.Exists(ctx, book, canto, verse)
+		if err != nil || exists {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return errors.New("timed out waiting for another replica to count " + key)
+		}
+		time.Sleep(fillPollInterval)
+	}
+
+	// the previous holder of the lock may have filled the verse just before we got it
+	if !refresh {
+		exists, err := store.Exists(ctx, book, canto, verse)
+		if err != nil || exists {
+			return err
+		}
+	}
+
+	resp, err := getWordCountFromMongo(book, canto, verse, ctx)
+	if err != nil {
+		return err
+	}
+
+	counts := countWords(resp.TextItalian)
+	span.LogFields(
+		openlog.String("key", key),
+		openlog.Int("words", len(counts)),
+	)
+	return store.Replace(ctx, book, canto, verse, counts, WordCountTTL)
+}
+
+// refreshIfExpiring starts a background fill when the counts of a verse are about to expire,
+// the request that noticed it is answered with the current counts
+func refreshIfExpiring(book string, canto int, verse int, ctx context.Context) {
+	if WordCountRefresh <= 0 || WordCountTTL <= 0 {
+		return
+	}
+
+	key := verseKey(book, canto, verse)
+	ttl, err := store.ExpiresIn(ctx, book, canto, verse)
+	if err != nil || ttl <= 0 || ttl > WordCountRefresh {
+		return
+	}
+
+	parent := opentracing.SpanFromContext(ctx)
+	go func() {
+		span := opentracing.GlobalTracer().StartSpan("refreshWordCount", opentracing.FollowsFrom(parent.Context()))
+		defer span.Finish()
+		refreshCtx := opentracing.ContextWithSpan(context.Background(), span)
+
+		err := fillOnce(key, func() error {
+			return fillVerse(book, canto, verse, true, refreshCtx)
+		})
+		if err != nil {
+			span.LogFields(
+				openlog.String("key", key),
+				openlog.Error(err),
+			)
+		}
+	}()
+}
+
+// countWords counts the words as they are stored, the tokenizer options of a request are applied when reading
+func countWords(text string) map[string]int {
+	counts := map[string]int{}
+	for _, word := range tokenizer.Tokenize(text, tokenizer.Options{}) {
+		counts[word]++
+	}
+	return counts
+}
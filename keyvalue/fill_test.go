@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrentRequestsFillOnce(t *testing.T) {
+	store = NewMemoryStore()
+	documents := newDocumentService(map[string]string{
+		"/api/inferno/1/1": "Nel mezzo del cammin di nostra vita",
+	})
+	defer documents.Close()
+	documents.delay = 100 * time.Millisecond
+
+	var wait sync.WaitGroup
+	codes := make([]int, 10)
+	for i := range codes {
+		wait.Add(1)
+		go func(i int) {
+			defer wait.Done()
+			rec := httptest.NewRecorder()
+			newRouter().ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/keyvalue/inferno/1/1", nil))
+			codes[i] = rec.Code
+		}(i)
+	}
+	wait.Wait()
+
+	for i, code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("request %d: expected status 200, got %d", i, code)
+		}
+	}
+	if calls := documents.calls(); len(calls) != 1 {
+		t.Errorf("expected the document service to be called once, got %v", calls)
+	}
+}
+
+func TestFillWaitsForTheLockHolder(t *testing.T) {
+	store = NewMemoryStore()
+	documents := newDocumentService(map[string]string{
+		"/api/inferno/1/1": "Nel mezzo del cammin di nostra vita",
+	})
+	defer documents.Close()
+	ctx := context.Background()
+
+	// another replica holds the lock and fills the verse while this one waits
+	unlock, ok, _ := store.Lock(ctx, "Inferno", 1, 1, fillLockTimeout)
+	if !ok {
+		t.Fatal("expected to get the lock")
+	}
+	go func() {
+		time.Sleep(3 * fillPollInterval)
+		store.Replace(ctx, "Inferno", 1, 1, map[string]int{"selva": 1}, 0)
+		unlock()
+	}()
+
+	rec := httptest.NewRecorder()
+	newRouter().ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/keyvalue/inferno/1/1", nil))
+
+	if rec.Code != http.StatusOK || rec.Body.String() != `{"selva":"1"}` {
+		t.Errorf("expected the counts of the lock holder, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if calls := documents.calls(); len(calls) != 0 {
+		t.Errorf("expected the document service not to be called, got %v", calls)
+	}
+}
+
+func TestFillReleasesTheLock(t *testing.T) {
+	store = NewMemoryStore()
+	documents := newDocumentService(map[string]string{
+		"/api/inferno/1/1": "Nel mezzo del cammin di nostra vita",
+	})
+	defer documents.Close()
+
+	for _, path := range []string{"/api/v1/keyvalue/inferno/1/1", "/api/v1/keyvalue/inferno/1/2"} {
+		rec := httptest.NewRecorder()
+		newRouter().ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+	}
+
+	// the fill of a missing verse fails, its lock has to be released all the same
+	for verse := 1; verse <= 2; verse++ {
+		unlock, ok, err := store.Lock(context.Background(), "Inferno", 1, verse, fillLockTimeout)
+		if err != nil || !ok {
+			t.Errorf("verse %d: expected the lock to be released after the fill", verse)
+			continue
+		}
+		unlock()
+	}
+}
+
+func TestEmptyVerseIsFilledOnce(t *testing.T) {
+	store = NewMemoryStore()
+	documents := newDocumentService(map[string]string{
+		"/api/inferno/1/1": "« ... »",
+	})
+	defer documents.Close()
+
+	for i := 0; i < 3; i++ {
+		rec := httptest.NewRecorder()
+		newRouter().ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/keyvalue/inferno/1/1", nil))
+		if rec.Code != http.StatusOK || rec.Body.String() != `{}` {
+			t.Fatalf("expected empty counts, got %d: %s", rec.Code, rec.Body.String())
+		}
+	}
+
+	if calls := documents.calls(); len(calls) != 1 {
+		t.Errorf("expected a verse without words to be fetched once, got %v", calls)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps the word counts in maps, it is used to run the service without redis.
+// Locks only guard this replica, which is all there is without redis
+type MemoryStore struct {
+	mutex    sync.Mutex
+	verses   map[string]map[string]int
+	expires  map[string]time.Time
+	rankings map[string]map[string]int
+	locks    map[string]time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		verses:   map[string]map[string]int{},
+		expires:  map[string]time.Time{},
+		rankings: map[string]map[string]int{},
+		locks:    map[string]time.Time{},
+	}
+}
+
+// counts returns the counts of a verse that has not expired, the mutex has to be held
+func (m *MemoryStore) counts(key string) (map[string]int, bool) {
+	counts, ok := m.verses[key]
+	if expires, ok := m.expires[key]; ok && time.Now().After(expires) {
+		delete(m.verses, key)
+		delete(m.expires, key)
+		return nil, false
+	}
+	return counts, ok
+}
+
+func (m *MemoryStore) Exists(ctx context.Context, book string, canto int, verse int) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	_, ok := m.counts(verseKey(book, canto, verse))
+	return ok, nil
+}
+
+func (m *MemoryStore) Counts(ctx context.Context, book string, canto int, verse int) (map[string]int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	stored, _ := m.counts(verseKey(book, canto, verse))
+	counts := make(map[string]int, len(stored))
+	for word, count := range stored {
+		counts[word] = count
+	}
+	return counts, nil
+}
+
+func (m *MemoryStore) Replace(ctx context.Context, book string, canto int, verse int, counts map[string]int, ttl time.Duration) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	key := verseKey(book, canto, verse)
+	scopes := rankingScopes(book, canto, verse)
+
+	// the verse ranking outlives an expired verse, it is what the verse added to the other rankings
+	for word, count := range m.rankings[scopes[0]] {
+		for _, scope := range scopes[1:] {
+			m.rank(scope, word, -count)
+		}
+	}
+	delete(m.rankings, scopes[0])
+	delete(m.verses, key)
+	delete(m.expires, key)
+
+	if counts == nil {
+		return nil
+	}
+
+	stored := make(map[string]int, len(counts))
+	for word, count := range counts {
+		stored[word] = count
+		for _, scope := range scopes {
+			m.rank(scope, word, count)
+		}
+	}
+	m.verses[key] = stored
+	if ttl > 0 {
+		m.expires[key] = time.Now().Add(ttl)
+	}
+	return nil
+}
+
+func (m *MemoryStore) Increment(ctx context.Context, book string, canto int, verse int, changes map[string]int) (map[string]int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	stored, ok := m.counts(verseKey(book, canto, verse))
+	if !ok {
+		return nil, ErrNotCounted
+	}
+	for word, by := range changes {
+		if stored[word]+by < 0 {
+			return nil, fmt.Errorf("%w: %s", ErrNegativeCount, word)
+		}
+	}
+
+	scopes := rankingScopes(book, canto, verse)
+	for word, by := range changes {
+		stored[word] += by
+		if stored[word] == 0 {
+			delete(stored, word)
+		}
+		for _, scope := range scopes {
+			m.rank(scope, word, by)
+		}
+	}
+	// like a redis hash a verse without words is gone
+	if len(stored) == 0 {
+		delete(m.verses, verseKey(book, canto, verse))
+	}
+
+	counts := make(map[string]int, len(stored))
+	for word, count := range stored {
+		counts[word] = count
+	}
+	return counts, nil
+}
+
+func (m *MemoryStore) rank(scope string, word string, by int) {
+	ranking, ok := m.rankings[scope]
+	if !ok {
+		ranking = map[string]int{}
+		m.rankings[scope] = ranking
+	}
+	ranking[word] += by
+	if ranking[word] <= 0 {
+		delete(ranking, word)
+	}
+}
+
+func (m *MemoryStore) ExpiresIn(ctx context.Context, book string, canto int, verse int) (time.Duration, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	expires, ok := m.expires[verseKey(book, canto, verse)]
+	if !ok {
+		return 0, nil
+	}
+	return time.Until(expires), nil
+}
+
+// Top orders words with the same count in reverse alphabetical order, the order ZREVRANGE returns them in
+func (m *MemoryStore) Top(ctx context.Context, scope string, n int) ([]WordRank, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	words := make([]WordRank, 0, len(m.rankings[scope]))
+	for word, count := range m.rankings[scope] {
+		words = append(words, WordRank{Word: word, Count: count})
+	}
+	sort.Slice(words, func(i, j int) bool {
+		if words[i].Count != words[j].Count {
+			return words[i].Count > words[j].Count
+		}
+		return words[i].Word > words[j].Word
+	})
+	if n >= 0 && len(words) > n {
+		words = words[:n]
+	}
+	return words, nil
+}
+
+func (m *MemoryStore) Lock(ctx context.Context, book string, canto int, verse int, timeout time.Duration) (func(), bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	key := verseKey(book, canto, verse)
+	if expires, ok := m.locks[key]; ok && time.Now().Before(expires) {
+		return nil, false, nil
+	}
+	taken := time.Now().Add(timeout)
+	m.locks[key] = taken
+
+	unlock := func() {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		if m.locks[key] == taken {
+			delete(m.locks, key)
+		}
+	}
+	return unlock, true, nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestRankings(t *testing.T) {
+	memory := NewMemoryStore()
+	ctx := context.Background()
+
+	type ranked map[string][]WordRank
+	check := func(step string, expected ranked) {
+		t.Helper()
+		for scope, words := range expected {
+			top, err := memory.Top(ctx, scope, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(words) == 0 && len(top) == 0 {
+				continue
+			}
+			if !reflect.DeepEqual(top, words) {
+				t.Errorf("%s: expected %s to be %v, got %v", step, scope, words, top)
+			}
+		}
+	}
+
+	memory.Replace(ctx, "Inferno", 1, 1, map[string]int{"selva": 2, "vita": 1}, 0)
+	memory.Replace(ctx, "Inferno", 1, 2, map[string]int{"selva": 1, "oscura": 1}, 0)
+	memory.Replace(ctx, "Purgatorio", 1, 1, map[string]int{"stelle": 3, "selva": 1}, 0)
+	check("after counting", ranked{
+		"verse:Inferno:1:1": {{"selva", 2}, {"vita", 1}},
+		"canto:Inferno:1":   {{"selva", 3}, {"vita", 1}, {"oscura", 1}},
+		"book:Inferno":      {{"selva", 3}, {"vita", 1}, {"oscura", 1}},
+		"book:Purgatorio":   {{"stelle", 3}, {"selva", 1}},
+		"corpus":            {{"selva", 4}, {"stelle", 3}, {"vita", 1}, {"oscura", 1}},
+	})
+
+	if _, err := memory.Increment(ctx, "Inferno", 1, 1, map[string]int{"vita": 2, "selva": -2}); err != nil {
+		t.Fatal(err)
+	}
+	check("after a patch", ranked{
+		"verse:Inferno:1:1": {{"vita", 3}},
+		"canto:Inferno:1":   {{"vita", 3}, {"selva", 1}, {"oscura", 1}},
+		"book:Inferno":      {{"vita", 3}, {"selva", 1}, {"oscura", 1}},
+		"corpus":            {{"vita", 3}, {"stelle", 3}, {"selva", 2}, {"oscura", 1}},
+	})
+
+	memory.Replace(ctx, "Inferno", 1, 1, map[string]int{"selva": 1}, 0)
+	check("after counting again", ranked{
+		"verse:Inferno:1:1": {{"selva", 1}},
+		"canto:Inferno:1":   {{"selva", 2}, {"oscura", 1}},
+		"book:Inferno":      {{"selva", 2}, {"oscura", 1}},
+		"corpus":            {{"stelle", 3}, {"selva", 3}, {"oscura", 1}},
+	})
+
+	memory.Replace(ctx, "Inferno", 1, 2, nil, 0)
+	check("after a delete", ranked{
+		"verse:Inferno:1:2": nil,
+		"canto:Inferno:1":   {{"selva", 1}},
+		"book:Inferno":      {{"selva", 1}},
+		"corpus":            {{"stelle", 3}, {"selva", 2}},
+	})
+
+	top, _ := memory.Top(ctx, "corpus", 1)
+	if !reflect.DeepEqual(top, []WordRank{{"stelle", 3}}) {
+		t.Errorf("expected only the highest ranked word, got %v", top)
+	}
+}
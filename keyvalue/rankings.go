@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/joerivrij/microbases/shared/models"
+	"github.com/joerivrij/microbases/shared/response"
+	"github.com/joerivrij/microbases/shared/tokenizer"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	openlog "github.com/opentracing/opentracing-go/log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultTopN = 20
+	maxTopN     = 500
+	corpusScope = "corpus"
+)
+
+// Ranking is the top of the most used words in a verse, canto, book or the whole commedia
+type Ranking struct {
+	Scope string     `json:"scope"`
+	Words []WordRank `json:"words"`
+}
+
+type WordRank struct {
+	Word  string `json:"word"`
+	Count int    `json:"count"`
+}
+
+func topHandler(w http.ResponseWriter, req *http.Request) {
+	spanCtx, _ := opentracing.GlobalTracer().Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(req.Header))
+	span := opentracing.GlobalTracer().StartSpan("topHandler", ext.RPCServerOption(spanCtx))
+	defer span.Finish()
+
+	ctx := opentracing.ContextWithSpan(req.Context(), span)
+
+	span.LogFields(
+		openlog.String("method", req.Method),
+		openlog.String("path", req.URL.Path),
+		openlog.String("host", req.Host),
+	)
+
+	query := req.URL.Query()
+	scope, err := parseScope(query.Get("scope"))
+	if err != nil {
+		response.RespondWithError(w, http.StatusBadRequest, err.Error(), ctx)
+		return
+	}
+
+	n := defaultTopN
+	if value := query.Get("n"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxTopN {
+			response.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("n has to be between 1 and %d", maxTopN), ctx)
+			return
+		}
+		n = parsed
+	}
+
+	words, err := topWords(scope, n, tokenizer.ParseOptions(query), ctx)
+	if err != nil {
+		respondWithStoreError(w, err, ctx)
+		return
+	}
+
+	respondWithJson(w, http.StatusOK, Ranking{Scope: scope, Words: words}, ctx)
+}
+
+// parseScope accepts corpus, book:<book>, canto:<book>:<canto> and verse:<book>:<canto>:<verse> and returns
+// it the way the ranking is stored, so book:inferno and book:Inferno read the same sorted set
+func parseScope(scope string) (string, error) {
+	parts := strings.Split(scope, ":")
+	switch {
+	case scope == "" || scope == corpusScope:
+		return corpusScope, nil
+	case parts[0] == "book" && len(parts) == 2:
+		book, ok := models.LookupBook(parts[1])
+		if !ok {
+			return "", fmt.Errorf("book %q is not a valid book", parts[1])
+		}
+		return "book:" + book.Name, nil
+	case parts[0] == "canto" && len(parts) == 3:
+		book, canto, _, err := parseVerseVars(map[string]string{"book": parts[1], "canto": parts[2], "verse": "1"})
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("canto:%s:%d", book, canto), nil
+	case parts[0] == "verse" && len(parts) == 4:
+		book, canto, verse, err := parseVerseVars(map[string]string{"book": parts[1], "canto": parts[2], "verse": parts[3]})
+		if err != nil {
+			return "", err
+		}
+		return "verse:" + verseKey(book, canto, verse), nil
+	}
+	return "", fmt.Errorf("scope %q has to be corpus, book:<book>, canto:<book>:<canto> or verse:<book>:<canto>:<verse>", scope)
+}
+
+// rankingScopes returns the rankings a word of a verse counts towards, from the verse up to the whole commedia
+func rankingScopes(book string, canto int, verse int) []string {
+	return []string{
+		"verse:" + verseKey(book, canto, verse),
+		fmt.Sprintf("canto:%s:%d", book, canto),
+		"book:" + book,
+		corpusScope,
+	}
+}
+
+// topWords reads the n highest ranked words. Words are ranked as they are in the text, when the options fold
+// or drop words the whole ranking is read so words that end up the same are added up before cutting off at n
+func topWords(scope string, n int, options tokenizer.Options, ctx context.Context) ([]WordRank, error) {
+	span, _ := opentracing.StartSpanFromContext(ctx, "topWords")
+	defer span.Finish()
+
+	limit := n
+	if options != (tokenizer.Options{}) {
+		limit = -1
+	}
+
+	ranked, err := store.Top(ctx, scope, limit)
+	if err != nil {
+		span.LogFields(
+			openlog.Error(err),
+		)
+		return nil, err
+	}
+
+	totals := map[string]int{}
+	var order []string
+	for _, rank := range ranked {
+		word, ok := options.Apply(rank.Word)
+		if !ok {
+			continue
+		}
+		if _, seen := totals[word]; !seen {
+			order = append(order, word)
+		}
+		totals[word] += rank.Count
+	}
+
+	words := make([]WordRank, 0, len(order))
+	for _, word := range order {
+		words = append(words, WordRank{Word: word, Count: totals[word]})
+	}
+	sort.SliceStable(words, func(i, j int) bool {
+		return words[i].Count > words[j].Count
+	})
+	if len(words) > n {
+		words = words[:n]
+	}
+	return words, nil
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/mediocregopher/radix.v2/pool"
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/opentracing/opentracing-go"
+	openlog "github.com/opentracing/opentracing-go/log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// unrankScript takes the words of a verse (KEYS[1]) off the canto, book and corpus rankings (KEYS[2..4]).
+// Redis cannot use a result inside a MULTI, so the lookup of the old ranking runs as a script
+const unrankScript = `
+local ranked = redis.call("ZRANGE", KEYS[1], 0, -1, "WITHSCORES")
+for i = 1, #ranked, 2 do
+	for k = 2, #KEYS do
+		redis.call("ZINCRBY", KEYS[k], -tonumber(ranked[i + 1]), ranked[i])
+	end
+end
+for k = 2, #KEYS do
+	redis.call("ZREMRANGEBYSCORE", KEYS[k], "-inf", 0)
+end
+return redis.call("DEL", KEYS[1])`
+
+// incrementScript changes the counts of a verse (KEYS[1]) and the verse, canto, book and corpus rankings
+// (KEYS[2..5]) by the word and change pairs in ARGV. It checks every word before it changes anything
+const incrementScript = `
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return redis.error_reply("NOTCOUNTED")
+end
+for i = 1, #ARGV, 2 do
+	local count = tonumber(redis.call("HGET", KEYS[1], ARGV[i]) or "0")
+	if count + tonumber(ARGV[i + 1]) < 0 then
+		return redis.error_reply("NEGATIVE " .. ARGV[i])
+	end
+end
+for i = 1, #ARGV, 2 do
+	if redis.call("HINCRBY", KEYS[1], ARGV[i], ARGV[i + 1]) == 0 then
+		redis.call("HDEL", KEYS[1], ARGV[i])
+	end
+	for k = 2, #KEYS do
+		redis.call("ZINCRBY", KEYS[k], ARGV[i + 1], ARGV[i])
+	end
+end
+for k = 2, #KEYS do
+	redis.call("ZREMRANGEBYSCORE", KEYS[k], "-inf", 0)
+end
+return redis.call("HGETALL", KEYS[1])`
+
+// releaseScript only removes the lock when it still holds our token, so a fill that lost its lock
+// to a timeout does not release the lock of the replica that took over
+const releaseScript = `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`
+
+// emptyVerse is the field a verse without words is stored under, redis has no hash without fields.
+// The tokenizer never returns an empty word so it cannot be mistaken for one
+const emptyVerse = ""
+
+// RedisStore keeps the counts of a verse in a hash under book:canto:verse and the rankings in sorted sets
+// under top:<scope>, the lock on filling a verse is lock:book:canto:verse
+type RedisStore struct {
+	pool *pool.Pool
+}
+
+func NewRedisStore(pool *pool.Pool) *RedisStore {
+	return &RedisStore{pool: pool}
+}
+
+func (r *RedisStore) Exists(ctx context.Context, book string, canto int, verse int) (bool, error) {
+	span, _ := opentracing.StartSpanFromContext(ctx, "keyExists")
+	defer span.Finish()
+
+	res, err := r.pool.Cmd("EXISTS", verseKey(book, canto, verse)).Int()
+	if err != nil {
+		return false, err
+	}
+	return res != 0, nil
+}
+
+func (r *RedisStore) Counts(ctx context.Context, book string, canto int, verse int) (map[string]int, error) {
+	span, _ := opentracing.StartSpanFromContext(ctx, "GetWordCount")
+	defer span.Finish()
+
+	stored, err := r.pool.Cmd("HGETALL", verseKey(book, canto, verse)).Map()
+	if err != nil {
+		return nil, err
+	}
+
+	return parseCounts(stored)
+}
+
+// Replace sends the whole write as one MULTI/EXEC transaction in a single round trip, readers either see the
+// old counts or the new ones. What the verse added to the rankings before is taken off by unrankScript
+func (r *RedisStore) Replace(ctx context.Context, book string, canto int, verse int, counts map[string]int, ttl time.Duration) error {
+	span, _ := opentracing.StartSpanFromContext(ctx, "writeCounts")
+	defer span.Finish()
+
+	key := verseKey(book, canto, verse)
+	rankings := redisRankingKeys(book, canto, verse)
+
+	conn, err := r.pool.Get()
+	if err != nil {
+		return err
+	}
+	defer r.pool.Put(conn)
+
+	commands := 0
+	appendCmd := func(cmd string, args ...interface{}) {
+		conn.PipeAppend(cmd, args...)
+		commands++
+	}
+
+	appendCmd("MULTI")
+	appendCmd("EVAL", unrankScript, len(rankings), rankings[0], rankings[1], rankings[2], rankings[3])
+	appendCmd("DEL", key)
+	if counts != nil && len(counts) == 0 {
+		appendCmd("HSET", key, emptyVerse, 0)
+	}
+	if len(counts) > 0 {
+		fields := []interface{}{key}
+		for word, count := range counts {
+			fields = append(fields, word, count)
+			for _, ranking := range rankings {
+				appendCmd("ZINCRBY", ranking, count, word)
+			}
+		}
+		appendCmd("HSET", fields...)
+	}
+	if ttl > 0 {
+		appendCmd("PEXPIRE", key, int64(ttl/time.Millisecond))
+	}
+	appendCmd("EXEC")
+
+	// every command but EXEC only answers QUEUED, the results of the transaction come with EXEC
+	var exec *redis.Resp
+	for i := 0; i < commands; i++ {
+		exec = conn.PipeResp()
+		if exec.Err != nil {
+			conn.PipeClear()
+			break
+		}
+	}
+	err = exec.Err
+	if err == nil {
+		var results []*redis.Resp
+		results, err = exec.Array()
+		for _, result := range results {
+			if result.Err != nil {
+				err = result.Err
+			}
+		}
+	}
+	if err != nil {
+		span.LogFields(
+			openlog.String("key", key),
+			openlog.Error(err),
+		)
+		return err
+	}
+
+	span.LogFields(
+		openlog.String("key", key),
+		openlog.Int("commands", commands),
+		openlog.String("ttl", ttl.String()),
+	)
+	return nil
+}
+
+// Increment runs incrementScript so the check and the change happen without another write in between
+func (r *RedisStore) Increment(ctx context.Context, book string, canto int, verse int, changes map[string]int) (map[string]int, error) {
+	span, _ := opentracing.StartSpanFromContext(ctx, "incrWordCount")
+	defer span.Finish()
+
+	rankings := redisRankingKeys(book, canto, verse)
+	args := []interface{}{incrementScript, 1 + len(rankings), verseKey(book, canto, verse)}
+	for _, ranking := range rankings {
+		args = append(args, ranking)
+	}
+	for word, by := range changes {
+		args = append(args, word, by)
+	}
+
+	stored, err := r.pool.Cmd("EVAL", args...).Map()
+	if err != nil {
+		message := err.Error()
+		switch {
+		case message == "NOTCOUNTED":
+			return nil, ErrNotCounted
+		case strings.HasPrefix(message, "NEGATIVE "):
+			return nil, fmt.Errorf("%w: %s", ErrNegativeCount, strings.TrimPrefix(message, "NEGATIVE "))
+		}
+		return nil, err
+	}
+
+	return parseCounts(stored)
+}
+
+func (r *RedisStore) ExpiresIn(ctx context.Context, book string, canto int, verse int) (time.Duration, error) {
+	ttl, err := r.pool.Cmd("PTTL", verseKey(book, canto, verse)).Int64()
+	if err != nil || ttl < 0 {
+		return 0, err
+	}
+	return time.Duration(ttl) * time.Millisecond, nil
+}
+
+func (r *RedisStore) Top(ctx context.Context, scope string, n int) ([]WordRank, error) {
+	values, err := r.pool.Cmd("ZREVRANGE", "top:"+scope, 0, n-1, "WITHSCORES").List()
+	if err != nil {
+		return nil, err
+	}
+
+	words := make([]WordRank, 0, len(values)/2)
+	for i := 0; i+1 < len(values); i += 2 {
+		count, err := strconv.ParseFloat(values[i+1], 64)
+		if err != nil {
+			return nil, err
+		}
+		words = append(words, WordRank{Word: values[i], Count: int(count)})
+	}
+	return words, nil
+}
+
+func (r *RedisStore) Lock(ctx context.Context, book string, canto int, verse int, timeout time.Duration) (func(), bool, error) {
+	lockKey := "lock:" + verseKey(book, canto, verse)
+	token, err := lockToken()
+	if err != nil {
+		return nil, false, err
+	}
+
+	resp := r.pool.Cmd("SET", lockKey, token, "NX", "PX", int64(timeout/time.Millisecond))
+	if resp.Err != nil {
+		return nil, false, resp.Err
+	}
+	if resp.IsType(redis.Nil) {
+		return nil, false, nil
+	}
+
+	unlock := func() {
+		r.pool.Cmd("EVAL", releaseScript, 1, lockKey, token)
+	}
+	return unlock, true, nil
+}
+
+// parseCounts reads the counts of a verse hash, leaving out the field of a verse without words
+func parseCounts(stored map[string]string) (map[string]int, error) {
+	counts := make(map[string]int, len(stored))
+	for word, count := range stored {
+		if word == emptyVerse {
+			continue
+		}
+		var err error
+		counts[word], err = strconv.Atoi(count)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return counts, nil
+}
+
+// redisRankingKeys returns the sorted sets a word of a verse counts towards, from the verse up to the whole commedia
+func redisRankingKeys(book string, canto int, verse int) []string {
+	scopes := rankingScopes(book, canto, verse)
+	keys := make([]string, len(scopes))
+	for i, scope := range scopes {
+		keys[i] = "top:" + scope
+	}
+	return keys
+}
+
+func lockToken() (string, error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(token), nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"github.com/mediocregopher/radix.v2/pool"
+	"os"
+	"testing"
+	"time"
+)
+
+// the benchmarks write under a book of their own and take it off the rankings again, still they are best run
+// against a redis that holds nothing else: REDIS_URL=localhost:6379 go test -bench . ./keyvalue
+const benchmarkBook = "Benchmark"
+
+var benchmarkCounts = countWords("Nel mezzo del cammin di nostra vita mi ritrovai per una selva oscura, ché la diritta via era smarrita.")
+
+func benchmarkPool(b *testing.B) *pool.Pool {
+	url := os.Getenv("REDIS_URL")
+	if url == "" {
+		b.Skip("REDIS_URL is not set")
+	}
+	p, err := pool.New("tcp", url, 4)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return p
+}
+
+// BenchmarkWritePerWord writes a verse the way it was written before Replace, a round trip per word and ranking
+func BenchmarkWritePerWord(b *testing.B) {
+	p := benchmarkPool(b)
+	defer p.Empty()
+
+	key := verseKey(benchmarkBook, 1, 1)
+	rankings := redisRankingKeys(benchmarkBook, 1, 1)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p.Cmd("DEL", key, rankings[0])
+		for word, count := range benchmarkCounts {
+			if err := p.Cmd("HINCRBY", key, word, count).Err; err != nil {
+				b.Fatal(err)
+			}
+			for _, ranking := range rankings {
+				p.Cmd("ZINCRBY", ranking, count, word)
+			}
+		}
+		p.Cmd("PEXPIRE", key, int64(time.Hour/time.Millisecond))
+	}
+	b.StopTimer()
+
+	for _, ranking := range rankings[1:] {
+		for word, count := range benchmarkCounts {
+			p.Cmd("ZINCRBY", ranking, -count*b.N, word)
+		}
+		p.Cmd("ZREMRANGEBYSCORE", ranking, "-inf", 0)
+	}
+	p.Cmd("DEL", key, rankings[0])
+}
+
+// BenchmarkReplace writes a verse in the single MULTI/EXEC round trip of RedisStore.Replace
+func BenchmarkReplace(b *testing.B) {
+	p := benchmarkPool(b)
+	defer p.Empty()
+
+	redisStore := NewRedisStore(p)
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := redisStore.Replace(ctx, benchmarkBook, 1, 1, benchmarkCounts, time.Hour); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+
+	redisStore.Replace(ctx, benchmarkBook, 1, 1, nil, 0)
+}
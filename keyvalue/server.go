@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"github.com/gorilla/mux"
 	"github.com/joerivrij/microbases/shared/models"
+	"github.com/joerivrij/microbases/shared/response"
+	"github.com/joerivrij/microbases/shared/tokenizer"
 	"github.com/joerivrij/microbases/shared/tracing"
 	"github.com/joho/godotenv"
 	"github.com/mediocregopher/radix.v2/pool"
@@ -20,8 +22,6 @@ import (
 	"log"
 )
 
-var db *pool.Pool
-
 var (
 	RedisUrl = "localhost:6379"
 )
@@ -71,33 +71,42 @@ func main() {
 	tracing.PrintServerInfo(ctx, logValue)
 	span.Finish()
 
-	startRedis()
+	loadCacheConfig()
+
+	// KEYVALUE_STORE=memory runs the service without redis, every replica then counts on its own
+	if os.Getenv("KEYVALUE_STORE") == "memory" {
+		store = NewMemoryStore()
+	} else {
+		startRedis()
+	}
+
+	panic(http.ListenAndServe(":"+port, newRouter()))
+}
 
+func newRouter() *mux.Router {
 	r := mux.NewRouter()
+	r.HandleFunc("/api/v1/keyvalue/top", topHandler).Methods("GET")
 	r.HandleFunc("/api/v1/keyvalue/{book}/{canto}/{verse}", searchHandler).Methods("GET")
 	r.HandleFunc("/api/v1/keyvalue/{book}/{canto}/{verse}", postHandler).Methods("POST")
-
-	panic(http.ListenAndServe(":"+port, r))
+	r.HandleFunc("/api/v1/keyvalue/{book}/{canto}/{verse}", patchHandler).Methods("PATCH")
+	r.HandleFunc("/api/v1/keyvalue/{book}/{canto}/{verse}", deleteHandler).Methods("DELETE")
+	return r
 }
 
 func startRedis() {
-	var err error
 	// Establish a pool of 15 connections to the Redis server listening on
 	// port 6379 of the variable that has been used
 	if os.Getenv("REDIS_URL") != "" {
 		RedisUrl = os.Getenv("REDIS_URL")
 	}
-	db, err = pool.New("tcp", RedisUrl, 15)
+	db, err := pool.New("tcp", RedisUrl, 15)
 	if err != nil {
+		log.Fatal("Could not connect to redis: ", err)
 	}
+	store = NewRedisStore(db)
 }
 
 func searchHandler(w http.ResponseWriter, req *http.Request) {
-	vars := mux.Vars(req)
-	book := vars["book"]
-	canto := vars["canto"]
-	verse := vars["verse"]
-
 	spanCtx, _ := opentracing.GlobalTracer().Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(req.Header))
 	span := opentracing.GlobalTracer().StartSpan("searchHandler", ext.RPCServerOption(spanCtx))
 	defer span.Finish()
@@ -111,37 +120,39 @@ func searchHandler(w http.ResponseWriter, req *http.Request) {
 		openlog.String("host", req.Host),
 	)
 
-	key := book + ":" + canto + ":" + verse
+	book, canto, verse, err := parseVerseVars(mux.Vars(req))
+	if err != nil {
+		response.RespondWithError(w, http.StatusBadRequest, err.Error(), ctx)
+		return
+	}
+	key := verseKey(book, canto, verse)
 
-	exists := keyExists(key, ctx)
+	exists, err := store.Exists(ctx, book, canto, verse)
+	if err != nil {
+		respondWithStoreError(w, err, ctx)
+		return
+	}
 	if !exists{
-		//todo create key as placeholder
-		resp := getWordCountFromMongo(key, w, req, ctx)
-
-		words := strings.Fields(resp.TextItalian)
-		for _, word := range words{
-			incrWordCount(key, word, ctx)
+		err := fillOnce(key, func() error {
+			return fillVerse(book, canto, verse, false, ctx)
+		})
+		if err != nil {
+			respondWithStoreError(w, err, ctx)
+			return
 		}
+	} else {
+		refreshIfExpiring(book, canto, verse, ctx)
 	}
 
-	c := getWordCount(key, ctx)
-	respondWithJson(w, 200, c, ctx)
-}
-
-func postHandler(w http.ResponseWriter, req *http.Request) {
-	vars := mux.Vars(req)
-	book := vars["book"]
-	canto := vars["canto"]
-	verse := vars["verse"]
-
-	var m PostBody
-
-	decoder := json.NewDecoder(req.Body)
-	if err := decoder.Decode(&m); err != nil {
-		respondWithJson(w, http.StatusBadRequest, req.Body, context.Background())
+	c, err := store.Counts(ctx, book, canto, verse)
+	if err != nil {
+		respondWithStoreError(w, err, ctx)
 		return
 	}
+	respondWithJson(w, 200, applyTokenOptions(c, tokenizer.ParseOptions(req.URL.Query())), ctx)
+}
 
+func postHandler(w http.ResponseWriter, req *http.Request) {
 	spanCtx, _ := opentracing.GlobalTracer().Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(req.Header))
 	span := opentracing.GlobalTracer().StartSpan("postHandler", ext.RPCServerOption(spanCtx))
 	defer span.Finish()
@@ -149,6 +160,13 @@ func postHandler(w http.ResponseWriter, req *http.Request) {
 	ctx := context.Background()
 	ctx = opentracing.ContextWithSpan(ctx, span)
 
+	var m PostBody
+	decoder := json.NewDecoder(req.Body)
+	if err := decoder.Decode(&m); err != nil {
+		response.RespondWithError(w, http.StatusBadRequest, "invalid body: "+err.Error(), ctx)
+		return
+	}
+
 	jsonBody, _ := json.Marshal(m)
 	span.LogFields(
 		openlog.String("method", req.Method),
@@ -157,40 +175,30 @@ func postHandler(w http.ResponseWriter, req *http.Request) {
 		openlog.String("body", string(jsonBody)),
 	)
 
-	key := book + ":" + canto + ":" + verse
-	words := strings.Fields(m.Words)
-	delWordCount(key, ctx)
-	for _, word := range words{
-		incrWordCount(key, word, ctx)
+	book, canto, verse, err := parseVerseVars(mux.Vars(req))
+	if err != nil {
+		response.RespondWithError(w, http.StatusBadRequest, err.Error(), ctx)
+		return
 	}
 
-	w.WriteHeader(201)
-	respondWithJson(w, 201, "Created", ctx)
-}
-
-func keyExists(key string, ctx context.Context) (bool) {
-	span, _ := opentracing.StartSpanFromContext(ctx, "keyExists")
-	span.SetTag("Method", "keyExists")
-
-	defer span.Finish()
-
-	res, err := db.Cmd("EXISTS", key).Int()
+	err = store.Replace(ctx, book, canto, verse, countWords(m.Words), WordCountTTL)
 	if err != nil {
-		println(err)
+		respondWithStoreError(w, err, ctx)
+		return
 	}
-	exists := res != 0
 
-	return exists
+	respondWithJson(w, http.StatusCreated, "Created", ctx)
 }
 
-func getWordCountFromMongo(key string, w http.ResponseWriter, req *http.Request, ctx context.Context) models.Canto {
+func getWordCountFromMongo(book string, canto int, verse int, ctx context.Context) (models.Canto, error) {
 	span, _ := opentracing.StartSpanFromContext(ctx, "getWordCountFromMongo")
-	var canto models.Canto
+	defer span.Finish()
+	var result models.Canto
 
-	url := fmt.Sprintf("http://%s/api/inferno/1/1", DocumentUrl)
+	url := fmt.Sprintf("http://%s/api/%s/%d/%d", DocumentUrl, strings.ToLower(book), canto, verse)
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		panic(err.Error())
+		return result, err
 	}
 
 	ext.SpanKindRPCClient.Set(span)
@@ -204,7 +212,11 @@ func getWordCountFromMongo(key string, w http.ResponseWriter, req *http.Request,
 
 	resp, err := microclient.BackendCall(req)
 	if err != nil {
-		panic(err.Error())
+		span.LogFields(
+			openlog.String("event", "Calling documentbase"),
+			openlog.Error(err),
+		)
+		return result, err
 	}
 
 	response := string(resp)
@@ -213,63 +225,54 @@ func getWordCountFromMongo(key string, w http.ResponseWriter, req *http.Request,
 		openlog.String("value", response),
 	)
 
-	if err := json.Unmarshal(resp, &canto); err != nil {
-		panic(err)
-	}
-	return canto
+	err = json.Unmarshal(resp, &result)
+	return result, err
 }
 
-func delWordCount(key string, ctx context.Context) {
-	span, _ := opentracing.StartSpanFromContext(ctx, "delWordCount")
-	span.SetTag("Method", "delWordCount")
-
-	defer span.Finish()
-
-	res, err := db.Cmd("EXISTS", key).Int()
-	if err != nil {
-		println(err)
+// parseVerseVars resolves the verse in the path so every way of writing it ends up on the same key,
+// the book can be any of its names and the canto arabic or roman (1, I, cantoi)
+func parseVerseVars(vars map[string]string) (string, int, int, error) {
+	book, ok := models.LookupBook(vars["book"])
+	if !ok {
+		return "", 0, 0, fmt.Errorf("book %q is not a valid book", vars["book"])
 	}
-	exists := res != 0
 
-	if exists {
-		db.Cmd("DEL", key)
+	canto, err := models.ParseCanto(vars["canto"])
+	if err != nil || canto > book.Cantos {
+		return "", 0, 0, fmt.Errorf("canto %q is not a valid canto of %s", vars["canto"], book.Name)
 	}
-}
-func incrWordCount(key string, word string, ctx context.Context) {
-	span, _ := opentracing.StartSpanFromContext(ctx, "incrWordCount")
-	span.SetTag("Method", "incrWordCount")
 
-	defer span.Finish()
-
-	err := db.Cmd("HINCRBY", key, word, 1).Err
-	if err != nil {
-		println(err)
+	verse, err := strconv.Atoi(vars["verse"])
+	if err != nil || verse < 1 {
+		return "", 0, 0, fmt.Errorf("verse %q is not a valid number", vars["verse"])
 	}
-	span.LogFields(
-		openlog.String("http_status_code", "200"),
-		openlog.String("body", "Increased " + word + " by 1"),
-	)
-	return
-}
-
-func getWordCount(key string, ctx context.Context) (map[string] string) {
-	span, _ := opentracing.StartSpanFromContext(ctx, "GetWordCount")
-	span.SetTag("Method", "GetWordCount")
 
-	defer span.Finish()
+	return book.Name, canto, verse, nil
+}
 
-	result, err := db.Cmd("HGETALL", key).Map()
-	if err != nil {
-		println(err)
+// applyTokenOptions folds and filters the stored counts, the store keeps every word with its accents so
+// one verse can answer for all options. Counts are returned as strings like they always have been
+func applyTokenOptions(counts map[string]int, options tokenizer.Options) map[string]string {
+	totals := map[string]int{}
+	for word, count := range counts {
+		word, ok := options.Apply(word)
+		if !ok {
+			continue
+		}
+		totals[word] += count
 	}
 
-	span.LogFields(
-		openlog.String("http_status_code", "200"),
-		openlog.String("body", "Increased by 1"),
-	)
+	result := make(map[string]string, len(totals))
+	for word, total := range totals {
+		result[word] = strconv.Itoa(total)
+	}
 	return result
 }
 
+func verseKey(book string, canto int, verse int) string {
+	return fmt.Sprintf("%s:%d:%d", book, canto, verse)
+}
+
 //generic method to respondwith json and log to jaeger
 func respondWithJson(w http.ResponseWriter, code int, payload interface{}, ctx context.Context) {
 	span, _ := opentracing.StartSpanFromContext(ctx, "Response")
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/joerivrij/microbases/shared/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// documentService answers like the document service for the verses it is given by path and records
+// which paths were asked for
+type documentService struct {
+	*httptest.Server
+	mutex sync.Mutex
+	paths []string
+	// delay holds every answer back, so concurrent requests overlap
+	delay time.Duration
+}
+
+func newDocumentService(verses map[string]string) *documentService {
+	d := &documentService{}
+	d.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		d.mutex.Lock()
+		d.paths = append(d.paths, req.URL.Path)
+		delay := d.delay
+		d.mutex.Unlock()
+		time.Sleep(delay)
+
+		text, ok := verses[req.URL.Path]
+		if !ok {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(models.Canto{TextItalian: text})
+	}))
+	DocumentUrl = strings.TrimPrefix(d.URL, "http://")
+	return d
+}
+
+func (d *documentService) calls() []string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return append([]string(nil), d.paths...)
+}
+
+func TestVersesAreKeptApart(t *testing.T) {
+	store = NewMemoryStore()
+	documents := newDocumentService(map[string]string{
+		"/api/inferno/1/1": "Nel mezzo del cammin di nostra vita",
+		"/api/inferno/1/2": "mi ritrovai per una selva oscura, selva",
+	})
+	defer documents.Close()
+
+	for _, path := range []string{"/api/v1/keyvalue/inferno/1/1", "/api/v1/keyvalue/inferno/I/2"} {
+		rec := httptest.NewRecorder()
+		newRouter().ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s: expected status 200, got %d: %s", path, rec.Code, rec.Body.String())
+		}
+	}
+
+	calls := documents.calls()
+	if len(calls) != 2 || calls[0] != "/api/inferno/1/1" || calls[1] != "/api/inferno/1/2" {
+		t.Errorf("expected the document service to be asked for both verses, got %v", calls)
+	}
+
+	first, _ := store.Counts(context.Background(), "Inferno", 1, 1)
+	second, _ := store.Counts(context.Background(), "Inferno", 1, 2)
+	if first["vita"] != 1 || first["selva"] != 0 {
+		t.Errorf("expected the counts of the first verse, got %v", first)
+	}
+	if second["selva"] != 2 || second["vita"] != 0 {
+		t.Errorf("expected the counts of the second verse, got %v", second)
+	}
+}
+
+func TestCantoSpellingsShareAKey(t *testing.T) {
+	for _, canto := range []string{"1", "I", "cantoi"} {
+		book, c, verse, err := parseVerseVars(map[string]string{"book": "inferno", "canto": canto, "verse": "1"})
+		if err != nil {
+			t.Fatalf("canto %s: %v", canto, err)
+		}
+		if key := verseKey(book, c, verse); key != "Inferno:1:1" {
+			t.Errorf("canto %s: expected the key Inferno:1:1, got %s", canto, key)
+		}
+	}
+}
+
+// brokenStore fails like a redis that cannot be reached
+type brokenStore struct{}
+
+var errBroken = errors.New("connection refused")
+
+func (brokenStore) Exists(ctx context.Context, book string, canto int, verse int) (bool, error) {
+	return false, errBroken
+}
+
+func (brokenStore) Counts(ctx context.Context, book string, canto int, verse int) (map[string]int, error) {
+	return nil, errBroken
+}
+
+func (brokenStore) Replace(ctx context.Context, book string, canto int, verse int, counts map[string]int, ttl time.Duration) error {
+	return errBroken
+}
+
+func (brokenStore) Increment(ctx context.Context, book string, canto int, verse int, changes map[string]int) (map[string]int, error) {
+	return nil, errBroken
+}
+
+func (brokenStore) ExpiresIn(ctx context.Context, book string, canto int, verse int) (time.Duration, error) {
+	return 0, errBroken
+}
+
+func (brokenStore) Top(ctx context.Context, scope string, n int) ([]WordRank, error) {
+	return nil, errBroken
+}
+
+func (brokenStore) Lock(ctx context.Context, book string, canto int, verse int, timeout time.Duration) (func(), bool, error) {
+	return nil, false, errBroken
+}
+
+func TestHandlers(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		body     string
+		broken   bool
+		status   int
+		contains string
+	}{
+		{"get counted verse", "GET", "/api/v1/keyvalue/inferno/1/1", "", false, http.StatusOK, `"vita":"1"`},
+		{"get verse to count", "GET", "/api/v1/keyvalue/inferno/1/2", "", false, http.StatusOK, `"selva":"1"`},
+		{"get invalid book", "GET", "/api/v1/keyvalue/limbo/1/1", "", false, http.StatusBadRequest, ""},
+		{"get invalid canto", "GET", "/api/v1/keyvalue/inferno/99/1", "", false, http.StatusBadRequest, ""},
+		{"get invalid verse", "GET", "/api/v1/keyvalue/inferno/1/zero", "", false, http.StatusBadRequest, ""},
+		{"get missing verse", "GET", "/api/v1/keyvalue/inferno/1/9", "", false, http.StatusNotFound, "verse not found"},
+		{"get failing document service", "GET", "/api/v1/keyvalue/inferno/1/3", "", false, http.StatusBadGateway, ""},
+		{"get without store", "GET", "/api/v1/keyvalue/inferno/1/1", "", true, http.StatusServiceUnavailable, ""},
+		{"post words", "POST", "/api/v1/keyvalue/inferno/1/5", `{"words": "selva selva"}`, false, http.StatusCreated, `"Created"`},
+		{"post invalid body", "POST", "/api/v1/keyvalue/inferno/1/5", `{"words": 1}`, false, http.StatusBadRequest, "invalid body: "},
+		{"post invalid canto", "POST", "/api/v1/keyvalue/inferno/99/5", `{"words": "selva"}`, false, http.StatusBadRequest, ""},
+		{"post without store", "POST", "/api/v1/keyvalue/inferno/1/5", `{"words": "selva"}`, true, http.StatusServiceUnavailable, ""},
+		{"patch counts", "PATCH", "/api/v1/keyvalue/inferno/1/1", `{"words": {"vita": 1}}`, false, http.StatusOK, `"vita":"2"`},
+		{"patch to zero", "PATCH", "/api/v1/keyvalue/inferno/1/1", `{"words": {"vita": -1}}`, false, http.StatusOK, ""},
+		{"patch below zero", "PATCH", "/api/v1/keyvalue/inferno/1/1", `{"words": {"vita": -2}}`, false, http.StatusConflict, ""},
+		{"patch uncounted verse", "PATCH", "/api/v1/keyvalue/inferno/1/2", `{"words": {"vita": 1}}`, false, http.StatusNotFound, ""},
+		{"patch nothing", "PATCH", "/api/v1/keyvalue/inferno/1/1", `{"words": {}}`, false, http.StatusBadRequest, ""},
+		{"patch capitalized word", "PATCH", "/api/v1/keyvalue/inferno/1/1", `{"words": {"Vita": 1}}`, false, http.StatusBadRequest, ""},
+		{"patch by zero", "PATCH", "/api/v1/keyvalue/inferno/1/1", `{"words": {"vita": 0}}`, false, http.StatusBadRequest, ""},
+		{"patch unknown field", "PATCH", "/api/v1/keyvalue/inferno/1/1", `{"word": {"vita": 1}}`, false, http.StatusBadRequest, ""},
+		{"patch without store", "PATCH", "/api/v1/keyvalue/inferno/1/1", `{"words": {"vita": 1}}`, true, http.StatusServiceUnavailable, ""},
+		{"delete verse", "DELETE", "/api/v1/keyvalue/inferno/1/1", "", false, http.StatusNoContent, ""},
+		{"delete invalid canto", "DELETE", "/api/v1/keyvalue/inferno/99/1", "", false, http.StatusBadRequest, ""},
+		{"delete without store", "DELETE", "/api/v1/keyvalue/inferno/1/1", "", true, http.StatusServiceUnavailable, ""},
+		{"top of the corpus", "GET", "/api/v1/keyvalue/top", "", false, http.StatusOK, `"scope":"corpus"`},
+		{"top of a canto", "GET", "/api/v1/keyvalue/top?scope=canto:inferno:I&n=1", "", false, http.StatusOK, `{"scope":"canto:Inferno:1","words":[{"word":"vita","count":1}]}`},
+		{"top of an invalid scope", "GET", "/api/v1/keyvalue/top?scope=stanza:1", "", false, http.StatusBadRequest, ""},
+		{"top of an invalid book", "GET", "/api/v1/keyvalue/top?scope=book:limbo", "", false, http.StatusBadRequest, ""},
+		{"top of no words", "GET", "/api/v1/keyvalue/top?n=0", "", false, http.StatusBadRequest, ""},
+		{"top of too many words", "GET", "/api/v1/keyvalue/top?n=501", "", false, http.StatusBadRequest, ""},
+		{"top without store", "GET", "/api/v1/keyvalue/top", "", true, http.StatusServiceUnavailable, ""},
+	}
+
+	documents := newDocumentService(map[string]string{
+		"/api/inferno/1/2": "mi ritrovai per una selva oscura,",
+	})
+	defer documents.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "mongo is down", http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store = NewMemoryStore()
+			store.Replace(context.Background(), "Inferno", 1, 1, countWords("Nel mezzo del cammin di nostra vita"), 0)
+			if test.broken {
+				store = brokenStore{}
+			}
+			DocumentUrl = strings.TrimPrefix(documents.URL, "http://")
+			if strings.HasSuffix(test.path, "/inferno/1/3") {
+				DocumentUrl = strings.TrimPrefix(failing.URL, "http://")
+			}
+
+			rec := httptest.NewRecorder()
+			newRouter().ServeHTTP(rec, httptest.NewRequest(test.method, test.path, strings.NewReader(test.body)))
+
+			if rec.Code != test.status {
+				t.Fatalf("expected status %d, got %d: %s", test.status, rec.Code, rec.Body.String())
+			}
+			if !strings.Contains(rec.Body.String(), test.contains) {
+				t.Errorf("expected %s in %s", test.contains, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestUnreachableDocumentService(t *testing.T) {
+	store = NewMemoryStore()
+	documents := newDocumentService(nil)
+	documents.Close()
+
+	rec := httptest.NewRecorder()
+	newRouter().ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/keyvalue/inferno/1/1", nil))
+
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("expected status 502, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	ErrNotCounted    = errors.New("the verse has not been counted yet")
+	ErrNegativeCount = errors.New("a count cannot go below zero")
+)
+
+// WordCountStore keeps the word counts of the verses and the rankings that are built from them.
+// Words are stored the way the tokenizer returns them without options
+type WordCountStore interface {
+	// Exists tells whether the verse has been counted and its counts have not expired
+	Exists(ctx context.Context, book string, canto int, verse int) (bool, error)
+	Counts(ctx context.Context, book string, canto int, verse int) (map[string]int, error)
+	// Replace sets the counts of a verse in one go and moves the rankings along, the counts expire after ttl unless it is 0.
+	// Empty counts store a verse without words so it is not fetched again, nil counts remove the verse
+	Replace(ctx context.Context, book string, canto int, verse int, counts map[string]int, ttl time.Duration) error
+	// Increment adds the changes to the counts of a verse and its rankings and returns the new counts. It fails with
+	// ErrNotCounted when the verse has no counts and with ErrNegativeCount, without changing anything, when a count
+	// would go below zero. Words that end up at zero are removed
+	Increment(ctx context.Context, book string, canto int, verse int, changes map[string]int) (map[string]int, error)
+	// ExpiresIn returns how long the counts of a verse are kept, 0 when they do not expire or do not exist
+	ExpiresIn(ctx context.Context, book string, canto int, verse int) (time.Duration, error)
+	// Top returns the n highest ranked words of a scope (see parseScope), all of them when n is below 0.
+	// Words with the same count come in reverse alphabetical order like redis has them
+	Top(ctx context.Context, scope string, n int) ([]WordRank, error)
+	// Lock takes the lock on filling a verse for at most timeout, ok is false when someone else holds it
+	Lock(ctx context.Context, book string, canto int, verse int, timeout time.Duration) (unlock func(), ok bool, err error)
+}
+
+var store WordCountStore
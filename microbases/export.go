@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/joerivrij/microbases/shared/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"io"
+)
+
+// exportCanti writes the canti in reading order, a file written by export can be imported again as is
+func exportCanti(db *mongo.Database, out io.Writer, format string, book string) (int, error) {
+	books := models.Books
+	if book != "" {
+		found, ok := models.LookupBook(book)
+		if !ok {
+			return 0, fmt.Errorf("book %q is not part of the commedia", book)
+		}
+		books = []models.Book{found}
+	}
+
+	ctx := context.Background()
+	order := options.Find().SetSort(bson.D{{Key: "arabic", Value: 1}, {Key: "verse", Value: 1}})
+
+	var canti []models.Canto
+	for _, book := range books {
+		var found []models.Canto
+		cursor, err := db.Collection(COLLECTION).Find(ctx, bson.M{"book": book.Name}, order)
+		if err == nil {
+			err = cursor.All(ctx, &found)
+		}
+		if err != nil {
+			return 0, err
+		}
+		canti = append(canti, found...)
+	}
+
+	return len(canti), writeCanti(out, format, canti)
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/joerivrij/microbases/shared/models"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// csvHeader is followed by a translations.<id> column for every translation in the file
+var csvHeader = []string{"book", "title", "roman", "arabic", "verse", "words", "textItalian"}
+
+// readCanti calls fn for every canto in the input together with the line it started on,
+// a canto that could not be read is passed with the error so the rest of the file is still read
+func readCanti(in io.Reader, format string, fn func(line int, canto models.Canto, err error) error) error {
+	switch format {
+	case "json":
+		return readJson(in, fn)
+	case "ndjson":
+		return readNdjson(in, fn)
+	case "csv":
+		return readCsv(in, fn)
+	}
+	return fmt.Errorf("unknown format %q", format)
+}
+
+func writeCanti(out io.Writer, format string, canti []models.Canto) error {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		if canti == nil {
+			canti = []models.Canto{}
+		}
+		return encoder.Encode(canti)
+	case "ndjson":
+		encoder := json.NewEncoder(out)
+		for _, canto := range canti {
+			if err := encoder.Encode(canto); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "csv":
+		return writeCsv(out, canti)
+	}
+	return fmt.Errorf("unknown format %q", format)
+}
+
+// readJson reads a single canto like deployment/db/json/cantoi.json, an array of them
+// or several objects after each other
+func readJson(in io.Reader, fn func(line int, canto models.Canto, err error) error) error {
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	lineAt := func(offset int64) int {
+		// the offset is where the decoder stopped, the canto itself starts after the separators
+		for offset < int64(len(data)) && bytes.IndexByte([]byte(" \t\r\n,"), data[offset]) != -1 {
+			offset++
+		}
+		return bytes.Count(data[:offset], []byte("\n")) + 1
+	}
+
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		if _, err := decoder.Token(); err != nil {
+			return err
+		}
+	}
+
+	for decoder.More() {
+		var raw json.RawMessage
+		start := decoder.InputOffset()
+		if err := decoder.Decode(&raw); err != nil {
+			// the decoder cannot continue after a syntax error so this ends the file
+			return fn(lineAt(start), models.Canto{}, err)
+		}
+
+		var canto models.Canto
+		err := json.Unmarshal(raw, &canto)
+		if err := fn(lineAt(start), canto, err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readNdjson(in io.Reader, fn func(line int, canto models.Canto, err error) error) error {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+
+		var canto models.Canto
+		err := json.Unmarshal(text, &canto)
+		if err := fn(line, canto, err); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func readCsv(in io.Reader, fn func(line int, canto models.Canto, err error) error) error {
+	reader := csv.NewReader(in)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return err
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[name] = i
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		line, _ := reader.FieldPos(0)
+		if err != nil {
+			if _, ok := err.(*csv.ParseError); !ok {
+				return err
+			}
+			if err := fn(line, models.Canto{}, err); err != nil {
+				return err
+			}
+			continue
+		}
+
+		canto, err := cantoFromRecord(columns, record)
+		if err := fn(line, canto, err); err != nil {
+			return err
+		}
+	}
+}
+
+func cantoFromRecord(columns map[string]int, record []string) (models.Canto, error) {
+	var canto models.Canto
+
+	value := func(name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return record[i]
+		}
+		return ""
+	}
+	number := func(name string) (int, error) {
+		if value(name) == "" {
+			return 0, nil
+		}
+		n, err := strconv.Atoi(value(name))
+		if err != nil {
+			return 0, fmt.Errorf("%s %q is not a number", name, value(name))
+		}
+		return n, nil
+	}
+
+	var err error
+	if canto.Arabic, err = number("arabic"); err != nil {
+		return canto, err
+	}
+	if canto.Verse, err = number("verse"); err != nil {
+		return canto, err
+	}
+	if canto.Words, err = number("words"); err != nil {
+		return canto, err
+	}
+	canto.Book = value("book")
+	canto.Title = value("title")
+	canto.Roman = value("roman")
+	canto.TextItalian = value("textItalian")
+
+	// files from before there were several translations have a textEnglish column
+	for name := range columns {
+		id := strings.TrimPrefix(name, "translations.")
+		if name == "textEnglish" {
+			id = models.DefaultTranslation
+		} else if id == name {
+			continue
+		}
+		if text := value(name); text != "" {
+			if canto.Translations == nil {
+				canto.Translations = map[string]string{}
+			}
+			canto.Translations[id] = text
+		}
+	}
+
+	return canto, nil
+}
+
+func writeCsv(out io.Writer, canti []models.Canto) error {
+	var ids []string
+	seen := map[string]bool{}
+	for _, canto := range canti {
+		for id := range canto.Translations {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	sort.Strings(ids)
+
+	header := append([]string{}, csvHeader...)
+	for _, id := range ids {
+		header = append(header, "translations."+id)
+	}
+
+	writer := csv.NewWriter(out)
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+
+	for _, canto := range canti {
+		record := []string{
+			canto.Book,
+			canto.Title,
+			canto.Roman,
+			strconv.Itoa(canto.Arabic),
+			strconv.Itoa(canto.Verse),
+			strconv.Itoa(canto.Words),
+			canto.TextItalian,
+		}
+		for _, id := range ids {
+			record = append(record, canto.Translations[id])
+		}
+
+		err := writer.Write(record)
+		if err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/joerivrij/microbases/shared/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ImportReport sums up an import, invalid canti are skipped and reported by the line they were on.
+// Inserted, Modified and Unchanged are what mongo did with the valid canti
+type ImportReport struct {
+	Read      int
+	Inserted  int
+	Modified  int
+	Unchanged int
+	Invalid   []InvalidCanto
+}
+
+type InvalidCanto struct {
+	Line int
+	Err  error
+}
+
+// importCanti upserts every valid canto on book, canto and verse so running the same file twice
+// leaves the collection as it is. Without a database the file is only validated, translations are then
+// not checked against the translations collection
+func importCanti(db *mongo.Database, in io.Reader, format string, batchSize int) (ImportReport, error) {
+	var report ImportReport
+	var batch []models.Canto
+
+	var known map[string]bool
+	if db != nil {
+		var err error
+		known, err = knownTranslations(db)
+		if err != nil {
+			return report, err
+		}
+	}
+
+	flush := func() error {
+		if db == nil || len(batch) == 0 {
+			batch = batch[:0]
+			return nil
+		}
+
+		now := time.Now().UTC()
+		var writes []mongo.WriteModel
+		for _, canto := range batch {
+			set := bson.M{
+				"title":       literal(canto.Title),
+				"roman":       literal(canto.Roman),
+				"words":       literal(canto.Words),
+				"textItalian": literal(canto.TextItalian),
+			}
+			// translations are set one by one so importing one translation leaves the others alone
+			for id, text := range canto.Translations {
+				set["translations."+id] = literal(text)
+			}
+
+			// modified only moves when a field differs from what is stored, so importing the same file
+			// again changes nothing and keeps Last-Modified where it was
+			var changed bson.A
+			for field, value := range set {
+				changed = append(changed, bson.M{"$ne": bson.A{"$" + field, value}})
+			}
+			touch := bson.M{"modified": bson.M{"$cond": bson.A{bson.M{"$or": changed}, now, "$modified"}}}
+
+			model := mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"book": canto.Book, "arabic": canto.Arabic, "verse": canto.Verse}).
+				SetUpdate(mongo.Pipeline{
+					{{Key: "$set", Value: touch}},
+					{{Key: "$set", Value: set}},
+				}).
+				SetUpsert(true)
+			writes = append(writes, model)
+		}
+
+		result, err := db.Collection(COLLECTION).BulkWrite(context.Background(), writes, options.BulkWrite().SetOrdered(false))
+		if err != nil {
+			return err
+		}
+		report.Inserted += int(result.UpsertedCount)
+		report.Modified += int(result.ModifiedCount)
+		report.Unchanged += int(result.MatchedCount - result.ModifiedCount)
+		batch = batch[:0]
+		return nil
+	}
+
+	err := readCanti(in, format, func(line int, canto models.Canto, err error) error {
+		report.Read++
+		if err == nil {
+			canto, err = normalizeCanto(canto)
+		}
+		if err == nil && known != nil {
+			err = checkTranslations(canto, known)
+		}
+		if err != nil {
+			report.Invalid = append(report.Invalid, InvalidCanto{Line: line, Err: err})
+			return nil
+		}
+
+		batch = append(batch, canto)
+		if len(batch) >= batchSize {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	return report, flush()
+}
+
+// knownTranslations returns the ids in the translations collection, a canto can only be imported with those
+// like a verse written through the document service
+func knownTranslations(db *mongo.Database) (map[string]bool, error) {
+	ids, err := db.Collection(TRANSLATIONS).Distinct(context.Background(), "_id", bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if id, ok := id.(string); ok {
+			known[id] = true
+		}
+	}
+	return known, nil
+}
+
+func checkTranslations(canto models.Canto, known map[string]bool) error {
+	var unknown []string
+	for id := range canto.Translations {
+		if !known[id] {
+			unknown = append(unknown, id)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown translation %s, add it with PUT /api/translations/{id} or migrate first", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// literal keeps a value in an update pipeline from being read as a field path or an operator
+func literal(value interface{}) bson.M {
+	return bson.M{"$literal": value}
+}
+
+// normalizeCanto stores the book under its catalog name and fills in the roman canto number when it is missing
+func normalizeCanto(canto models.Canto) (models.Canto, error) {
+	if book, ok := models.LookupBook(canto.Book); ok {
+		canto.Book = book.Name
+	}
+	if canto.Roman == "" && canto.Arabic > 0 {
+		canto.Roman = models.ToRoman(canto.Arabic)
+	}
+	return canto, canto.Validate()
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"github.com/joerivrij/microbases/shared/models"
+	"testing"
+)
+
+func TestCheckTranslations(t *testing.T) {
+	known := map[string]bool{"default": true, "longfellow": true}
+	tests := []struct {
+		name         string
+		translations map[string]string
+		valid        bool
+	}{
+		{"no translations", nil, true},
+		{"known translations", map[string]string{"default": "In the midway", "longfellow": "Midway upon"}, true},
+		{"unknown translation", map[string]string{"default": "In the midway", "nobody": "Halfway"}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := checkTranslations(models.Canto{Translations: test.translations}, known)
+			if (err == nil) != test.valid {
+				t.Errorf("expected valid %v, got %v", test.valid, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/joerivrij/microbases/shared/models"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	COLLECTION = "canti"
+	DATABASE   = "divinacommedia"
+)
+
+const usage = `Usage: microbases <command> [flags] <file>
+
+Commands:
+  import   upsert the canti in a json, ndjson or csv file into mongo
+  export   write the canti in mongo to a json, ndjson or csv file
+  migrate  move the textEnglish of every canto into the default translation
+
+Run microbases <command> -h to see the flags of a command
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "import":
+		err = runImport(os.Args[2:])
+	case "export":
+		err = runExport(os.Args[2:])
+	case "migrate":
+		err = runMigrate(os.Args[2:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "microbases:", err)
+		os.Exit(1)
+	}
+}
+
+func runImport(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	mongoUrl := flags.String("mongo", mongoUrlFromEnv(), "mongo server to import into")
+	format := flags.String("format", "", "json, ndjson or csv, taken from the file extension when empty")
+	batchSize := flags.Int("batch", 500, "number of canti upserted per round trip")
+	dryRun := flags.Bool("dry-run", false, "only validate the file")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("import needs exactly one file")
+	}
+	file := flags.Arg(0)
+
+	if *format == "" {
+		*format = formatFromExtension(file)
+	}
+
+	in, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	var db *mongo.Database
+	if !*dryRun {
+		client, err := connect(*mongoUrl)
+		if err != nil {
+			return err
+		}
+		defer client.Disconnect(context.Background())
+		db = client.Database(DATABASE)
+	}
+
+	report, err := importCanti(db, in, *format, *batchSize)
+	if err != nil {
+		return err
+	}
+
+	for _, invalid := range report.Invalid {
+		fmt.Fprintf(os.Stderr, "%s:%d: %s\n", file, invalid.Line, invalid.Err)
+	}
+	fmt.Printf("read %d canti, %d invalid, %d inserted, %d changed, %d unchanged\n",
+		report.Read, len(report.Invalid), report.Inserted, report.Modified, report.Unchanged)
+
+	if len(report.Invalid) > 0 {
+		return fmt.Errorf("%d canti in %s are invalid", len(report.Invalid), file)
+	}
+	return nil
+}
+
+func runExport(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	mongoUrl := flags.String("mongo", mongoUrlFromEnv(), "mongo server to export from")
+	format := flags.String("format", "", "json, ndjson or csv, taken from the file extension when empty")
+	book := flags.String("book", "", "only export a single book")
+	flags.Parse(args)
+
+	file := "-"
+	if flags.NArg() > 0 {
+		file = flags.Arg(0)
+	}
+
+	if *format == "" {
+		*format = formatFromExtension(file)
+	}
+
+	client, err := connect(*mongoUrl)
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect(context.Background())
+
+	out := os.Stdout
+	if file != "-" {
+		out, err = os.Create(file)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+	}
+
+	count, err := exportCanti(client.Database(DATABASE), out, *format, *book)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "exported %d canti\n", count)
+	return nil
+}
+
+func runMigrate(args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	mongoUrl := flags.String("mongo", mongoUrlFromEnv(), "mongo server to migrate")
+	translator := flags.String("translator", "", "translator of the existing english text")
+	year := flags.Int("year", 0, "year the existing english translation was published")
+	license := flags.String("license", "", "license of the existing english translation")
+	flags.Parse(args)
+
+	client, err := connect(*mongoUrl)
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect(context.Background())
+
+	translation := models.Translation{
+		ID:         models.DefaultTranslation,
+		Translator: *translator,
+		Language:   "en",
+		Year:       *year,
+		License:    *license,
+	}
+	migrated, err := migrateTranslations(client.Database(DATABASE), translation)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("moved the english text of %d canti to translation %s\n", migrated, translation.ID)
+	return nil
+}
+
+func mongoUrlFromEnv() string {
+	if url := os.Getenv("MONGO_URL"); url != "" {
+		return url
+	}
+	return "localhost:27017"
+}
+
+func formatFromExtension(file string) string {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".csv":
+		return "csv"
+	case ".ndjson", ".jsonl":
+		return "ndjson"
+	default:
+		return "json"
+	}
+}
+
+func connect(mongoUrl string) (*mongo.Client, error) {
+	if !strings.Contains(mongoUrl, "://") {
+		mongoUrl = "mongodb://" + mongoUrl
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoUrl))
+	if err != nil {
+		return nil, err
+	}
+	return client, client.Ping(ctx, nil)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"github.com/joerivrij/microbases/shared/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+const TRANSLATIONS = "translations"
+
+// migrateTranslations stores the metadata of the default translation and moves the textEnglish field of
+// every canto into it. Canti that are already migrated are not matched so it can be run again safely
+func migrateTranslations(db *mongo.Database, translation models.Translation) (int64, error) {
+	ctx := context.Background()
+
+	_, err := db.Collection(TRANSLATIONS).ReplaceOne(ctx, bson.M{"_id": translation.ID}, translation, options.Replace().SetUpsert(true))
+	if err != nil {
+		return 0, err
+	}
+
+	// an empty textEnglish never held a translation, it is only removed. A verse that already has the
+	// translation was edited after it was loaded, that text is newer than textEnglish and is kept
+	field := "translations." + translation.ID
+	pipeline := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			field:      bson.M{"$ifNull": bson.A{"$" + field, "$textEnglish"}},
+			"modified": time.Now().UTC(),
+		}}},
+		{{Key: "$unset", Value: "textEnglish"}},
+	}
+	result, err := db.Collection(COLLECTION).UpdateMany(ctx, bson.M{"textEnglish": bson.M{"$exists": true, "$ne": ""}}, pipeline)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = db.Collection(COLLECTION).UpdateMany(ctx, bson.M{"textEnglish": ""}, bson.M{"$unset": bson.M{"textEnglish": ""}})
+	if err != nil {
+		return result.ModifiedCount, err
+	}
+	return result.ModifiedCount, nil
+}
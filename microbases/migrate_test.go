@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"github.com/joerivrij/microbases/shared/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"os"
+	"testing"
+)
+
+// testDatabase connects to the mongo of MONGO_URL and hands out a database of its own that is dropped afterwards
+func testDatabase(t *testing.T) *mongo.Database {
+	url := os.Getenv("MONGO_URL")
+	if url == "" {
+		t.Skip("MONGO_URL is not set")
+	}
+	client, err := connect(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := client.Database("microbases_test")
+	t.Cleanup(func() {
+		db.Drop(context.Background())
+		client.Disconnect(context.Background())
+	})
+	return db
+}
+
+func TestMigrateTranslations(t *testing.T) {
+	db := testDatabase(t)
+	ctx := context.Background()
+
+	_, err := db.Collection(COLLECTION).InsertMany(ctx, []interface{}{
+		bson.M{"book": "Inferno", "arabic": 1, "verse": 1, "textItalian": "Nel mezzo", "textEnglish": "Midway upon"},
+		bson.M{"book": "Inferno", "arabic": 1, "verse": 2, "textItalian": "mi ritrovai", "textEnglish": "I found me",
+			"translations": bson.M{"default": "I found myself"}},
+		bson.M{"book": "Inferno", "arabic": 1, "verse": 3, "textItalian": "ché la diritta", "textEnglish": ""},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	migrated, err := migrateTranslations(db, models.Translation{ID: models.DefaultTranslation, Language: "en"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migrated != 2 {
+		t.Errorf("expected 2 migrated canti, got %d", migrated)
+	}
+
+	expected := map[int]string{1: "Midway upon", 2: "I found myself", 3: ""}
+	for verse, text := range expected {
+		var stored bson.M
+		err := db.Collection(COLLECTION).FindOne(ctx, bson.M{"verse": verse}).Decode(&stored)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := stored["textEnglish"]; ok {
+			t.Errorf("verse %d: expected textEnglish to be removed", verse)
+		}
+		translations, _ := stored["translations"].(bson.M)
+		if got, _ := translations["default"].(string); got != text {
+			t.Errorf("verse %d: expected the default translation %q, got %q", verse, text, got)
+		}
+	}
+}
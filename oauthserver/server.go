@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/joerivrij/microbases/shared/tracing"
 	openlog "github.com/opentracing/opentracing-go/log"
@@ -14,6 +15,7 @@ import (
 	"gopkg.in/oauth2.v3/store"
 	"net/http"
 	"os"
+	"time"
 
 	"log"
 )
@@ -110,5 +112,34 @@ func main() {
 		srv.HandleTokenRequest(w, r)
 	})
 
+	// validate lets the other services check a bearer token and find out who it was handed out to
+	http.HandleFunc("/validate", func(w http.ResponseWriter, r *http.Request) {
+		spanCtx, _ := opentracing.GlobalTracer().Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(r.Header))
+		span := opentracing.GlobalTracer().StartSpan("validateHandler", ext.RPCServerOption(spanCtx))
+		defer span.Finish()
+
+		span.LogFields(
+			openlog.String("method", r.Method),
+			openlog.String("path", r.URL.Path),
+			openlog.String("host", r.Host),
+		)
+
+		token, err := srv.ValidationBearerToken(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		expiresAt := token.GetAccessCreateAt().Add(token.GetAccessExpiresIn())
+		data := map[string]interface{}{
+			"client_id":  token.GetClientID(),
+			"user_id":    token.GetUserID(),
+			"scope":      token.GetScope(),
+			"expires_in": int64(time.Until(expiresAt).Seconds()),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(data)
+	})
+
 	log.Fatal(http.ListenAndServe(":" + port, nil))
 }
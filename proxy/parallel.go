@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	microclient "github.com/joerivrij/microbases/shared/client"
+	"github.com/joerivrij/microbases/shared/models"
+	"github.com/joerivrij/microbases/shared/response"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	openlog "github.com/opentracing/opentracing-go/log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ParallelCanto shows a canto with the original and a translation next to each other, the path
+// is /parallel/{book}/{canto} and the canto can be given in arabic or roman numbers. The translation
+// query parameter picks another translation than the default one
+func ParallelCanto(w http.ResponseWriter, req *http.Request) {
+	span := opentracing.GlobalTracer().StartSpan("parallelCanto")
+	defer span.Finish()
+
+	ctx := opentracing.ContextWithSpan(context.Background(), span)
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(req.URL.Path, "/parallel/"), "/"), "/")
+	if len(parts) != 2 {
+		response.RespondWithError(w, http.StatusNotFound, "use /parallel/{book}/{canto}", ctx)
+		return
+	}
+
+	canto := parts[1]
+	if arabic, err := models.ParseCanto(canto); err == nil {
+		canto = fmt.Sprint(arabic)
+	}
+
+	parallel, err := fetchParallelCanto(ctx, parts[0], canto, req.URL.Query().Get("translation"))
+	if statusErr, ok := err.(*microclient.StatusError); ok {
+		var backendErr response.ErrorResponse
+		json.Unmarshal(statusErr.Body, &backendErr)
+		response.RespondWithError(w, statusErr.StatusCode, backendErr.Message, ctx)
+		return
+	}
+	if err != nil {
+		response.RespondWithError(w, http.StatusBadGateway, err.Error(), ctx)
+		return
+	}
+
+	render(w, "parallel.html", parallel)
+}
+
+func fetchParallelCanto(ctx context.Context, book string, canto string, translation string) (models.ParallelCanto, error) {
+	span, _ := opentracing.StartSpanFromContext(ctx, "fetchParallelCanto")
+	defer span.Finish()
+
+	var parallel models.ParallelCanto
+	documentUrl := fmt.Sprintf("http://%s/api/%s/%s/parallel", DocumentUrl, url.PathEscape(book), url.PathEscape(canto))
+	if translation != "" {
+		documentUrl += "?translation=" + url.QueryEscape(translation)
+	}
+	req, err := http.NewRequest("GET", documentUrl, nil)
+	if err != nil {
+		return parallel, err
+	}
+
+	ext.SpanKindRPCClient.Set(span)
+	ext.HTTPUrl.Set(span, documentUrl)
+	ext.HTTPMethod.Set(span, "GET")
+	span.Tracer().Inject(
+		span.Context(),
+		opentracing.HTTPHeaders,
+		opentracing.HTTPHeadersCarrier(req.Header),
+	)
+
+	body, err := microclient.BackendCall(req)
+	if err != nil {
+		span.LogFields(
+			openlog.String("event", "calling document backend"),
+			openlog.Error(err),
+		)
+		return parallel, err
+	}
+
+	span.LogFields(
+		openlog.String("event", "calling document backend"),
+		openlog.Int("value", len(body)),
+	)
+
+	return parallel, json.Unmarshal(body, &parallel)
+}
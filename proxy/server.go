@@ -13,6 +13,7 @@ import (
 	"html/template"
 	"log"
 	"net/http"
+	neturl "net/url"
 	"os"
 )
 
@@ -33,7 +34,7 @@ var (
 	GraphUrl =  "localhost:3220"
 )
 
-func render(w http.ResponseWriter, tmpl string) {
+func render(w http.ResponseWriter, tmpl string, data interface{}) {
 	templateDir := os.Getenv("STATIC_CONTENT_DIR")
 	tmpl = fmt.Sprintf(templateDir + "templates/%s", tmpl) // prefix the name passed in with templates/
 	t, err := template.ParseFiles(tmpl)      //parse the template file held in the templates folder
@@ -42,7 +43,7 @@ func render(w http.ResponseWriter, tmpl string) {
 		fmt.Print("template parsing error: ", err)
 	}
 
-	err = t.Execute(w, nil) //execute the template and pass in the variables to fill the gaps
+	err = t.Execute(w, data) //execute the template and pass in the variables to fill the gaps
 
 	if err != nil {
 		fmt.Print("template executing error: ", err)
@@ -50,7 +51,7 @@ func render(w http.ResponseWriter, tmpl string) {
 }
 
 func HomePage(w http.ResponseWriter, r *http.Request){
-	render(w, "home.html")
+	render(w, "home.html", nil)
 }
 
 func QueryWordCount(w http.ResponseWriter, req *http.Request)  {
@@ -67,7 +68,13 @@ func QueryWordCount(w http.ResponseWriter, req *http.Request)  {
 		openlog.String("value", token),
 	)
 
-	url := fmt.Sprintf("http://%s/api/v1/keyvalue/inferno/cantoi/1", KeyvalueUrl)
+	// the verse to count defaults to the opening verse of the inferno
+	query := req.URL.Query()
+	book := queryOrDefault(query, "book", "inferno")
+	canto := queryOrDefault(query, "canto", "1")
+	verse := queryOrDefault(query, "verse", "1")
+
+	url := fmt.Sprintf("http://%s/api/v1/keyvalue/%s/%s/%s", KeyvalueUrl, neturl.PathEscape(book), neturl.PathEscape(canto), neturl.PathEscape(verse))
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		panic(err.Error())
@@ -96,6 +103,12 @@ func QueryWordCount(w http.ResponseWriter, req *http.Request)  {
 	response.RespondWithJson(w, 200, helloStr, ctx)
 }
 
+func queryOrDefault(query neturl.Values, key string, fallback string) string {
+	if value := query.Get(key); value != "" {
+		return value
+	}
+	return fallback
+}
 
 func GetToken(ctx context.Context, req *http.Request) string {
 	span, _ := opentracing.StartSpanFromContext(ctx, "getToken")
@@ -177,6 +190,7 @@ func main() {
 	mux.Handle("/static/", http.StripPrefix("/static/", fs))
 	mux.HandleFunc("/", HomePage)
 	mux.HandleFunc("/queryWordCount", QueryWordCount)
+	mux.HandleFunc("/parallel/", ParallelCanto)
 	panic(http.ListenAndServe(":3201", mux))
 
 }
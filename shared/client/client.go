@@ -6,6 +6,16 @@ import (
 	"net/http"
 )
 
+// StatusError is returned when a backend answers with anything but a 200
+type StatusError struct {
+	StatusCode int
+	Body       []byte
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("StatusCode: %d, Body: %s", e.StatusCode, e.Body)
+}
+
 func BackendCall(req *http.Request) ([]byte, error) {
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
@@ -19,8 +29,8 @@ func BackendCall(req *http.Request) ([]byte, error) {
 	}
 
 	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("StatusCode: %d, Body: %s", resp.StatusCode, body)
+		return nil, &StatusError{StatusCode: resp.StatusCode, Body: body}
 	}
 
 	return body, nil
-}
\ No newline at end of file
+}
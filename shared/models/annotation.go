@@ -0,0 +1,76 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"strings"
+	"time"
+)
+
+const (
+	maxTagLength = 50
+	maxTags      = 20
+)
+
+// Annotation is a note on a verse or a range of verses within one canto
+type Annotation struct {
+	ID       primitive.ObjectID `bson:"_id" json:"id"`
+	Book     string             `bson:"book" json:"book"`
+	Arabic   int                `bson:"arabic" json:"canto"`
+	From     int                `bson:"from" json:"from"`
+	To       int                `bson:"to" json:"to"`
+	Text     string             `bson:"text" json:"text"`
+	Tags     []string           `bson:"tags" json:"tags"`
+	Author   string             `bson:"author" json:"author"`
+	Created  time.Time          `bson:"created" json:"created"`
+	Modified time.Time          `bson:"modified" json:"modified"`
+}
+
+// Covers tells whether the annotation is about the given verse
+func (a Annotation) Covers(verse int) bool {
+	return verse >= a.From && verse <= a.To
+}
+
+// Validate checks the annotation and brings its tags to lowercase without duplicates
+func (a *Annotation) Validate() error {
+	book, ok := LookupBook(a.Book)
+	if !ok {
+		return fmt.Errorf("book %q is not part of the commedia", a.Book)
+	}
+	if a.Arabic < 1 || a.Arabic > book.Cantos {
+		return fmt.Errorf("canto must be between 1 and %d for %s", book.Cantos, book.Name)
+	}
+	if a.From < 1 {
+		return errors.New("from must be a positive verse number")
+	}
+	if a.To == 0 {
+		a.To = a.From
+	}
+	if a.To < a.From {
+		return errors.New("to cannot come before from")
+	}
+	if strings.TrimSpace(a.Text) == "" {
+		return errors.New("text is required")
+	}
+
+	tags := []string{}
+	seen := map[string]bool{}
+	for _, tag := range a.Tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if len(tag) > maxTagLength {
+			return fmt.Errorf("tag %q is longer than %d characters", tag, maxTagLength)
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	if len(tags) > maxTags {
+		return fmt.Errorf("an annotation can have at most %d tags", maxTags)
+	}
+	a.Book = book.Name
+	a.Tags = tags
+	return nil
+}
@@ -0,0 +1,34 @@
+package models
+
+import "strings"
+
+// Book is one of the three cantiche of the Divine Comedy
+type Book struct {
+	Name    string   `json:"name"`
+	Cantos  int      `json:"cantos"`
+	Verses  int      `json:"verses"`
+	Aliases []string `json:"aliases"`
+}
+
+// Books is the catalog of all cantiche in reading order
+var Books = []Book{
+	{Name: "Inferno", Cantos: 34, Verses: 4720, Aliases: []string{"inf", "hell"}},
+	{Name: "Purgatorio", Cantos: 33, Verses: 4755, Aliases: []string{"purg", "purgatory"}},
+	{Name: "Paradiso", Cantos: 33, Verses: 4758, Aliases: []string{"par", "parad", "paradise", "heaven"}},
+}
+
+// LookupBook finds a book by its name or one of its aliases, ignoring case and a trailing dot so "Inf." works too
+func LookupBook(name string) (Book, bool) {
+	name = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(name), "."))
+	for _, book := range Books {
+		if strings.ToLower(book.Name) == name {
+			return book, true
+		}
+		for _, alias := range book.Aliases {
+			if alias == name {
+				return book, true
+			}
+		}
+	}
+	return Book{}, false
+}
@@ -1,9 +1,16 @@
 package models
 
-import "gopkg.in/mgo.v2/bson"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
 
 type Canto struct {
-	ID    bson.ObjectId `bson:"_id" json:"id"`
+	ID    primitive.ObjectID `bson:"_id,omitempty" json:"id"`
 	Book string        `bson:"book" json:"book"`
 	Title string        `bson:"title" json:"title"`
 	Roman string        `bson:"roman" json:"roman"`
@@ -11,6 +18,95 @@ type Canto struct {
 	Verse  int           `bson:"verse" json:"verse"`
 	Words  int           `bson:"words" json:"words"`
 	TextItalian  string        `bson:"textItalian" json:"textItalian"`
-	TextEnglish  string        `bson:"textEnglish" json:"textEnglish"`
+	// Translations holds the text of the verse per translation id, see Translation
+	Translations map[string]string `bson:"translations,omitempty" json:"translations,omitempty"`
+	// Modified is when the verse was last written, it is sent as the Last-Modified header instead of in the body
+	Modified time.Time `bson:"modified,omitempty" json:"-"`
 }
 
+// UnmarshalJSON still accepts the textEnglish field from before there were several translations
+// and reads it as the default translation
+func (c *Canto) UnmarshalJSON(data []byte) error {
+	type canto Canto
+	var legacy struct {
+		canto
+		TextEnglish string `json:"textEnglish"`
+	}
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return err
+	}
+
+	*c = Canto(legacy.canto)
+	c.legacyEnglish(legacy.TextEnglish)
+	return nil
+}
+
+// UnmarshalBSON reads the textEnglish field of canti the migrate command has not moved yet as the default translation
+func (c *Canto) UnmarshalBSON(data []byte) error {
+	type canto Canto
+	var legacy struct {
+		Canto       canto  `bson:",inline"`
+		TextEnglish string `bson:"textEnglish"`
+	}
+	if err := bson.Unmarshal(data, &legacy); err != nil {
+		return err
+	}
+
+	*c = Canto(legacy.Canto)
+	c.legacyEnglish(legacy.TextEnglish)
+	return nil
+}
+
+func (c *Canto) legacyEnglish(text string) {
+	if text == "" {
+		return
+	}
+	if c.Translations == nil {
+		c.Translations = map[string]string{}
+	}
+	if _, ok := c.Translations[DefaultTranslation]; !ok {
+		c.Translations[DefaultTranslation] = text
+	}
+}
+
+// MarshalJSON keeps sending textEnglish next to the translations, it holds the default translation
+// so clients from before there were several translations see the same verse as before
+func (c Canto) MarshalJSON() ([]byte, error) {
+	type canto Canto
+	return json.Marshal(struct {
+		canto
+		TextEnglish string `json:"textEnglish"`
+	}{canto(c), c.Translations[DefaultTranslation]})
+}
+
+// Translation returns the text of the verse in the given translation
+func (c Canto) Translation(id string) (string, bool) {
+	text, ok := c.Translations[id]
+	return text, ok
+}
+
+// Validate checks that a canto has everything needed to be stored
+func (c Canto) Validate() error {
+	book, ok := LookupBook(c.Book)
+	if !ok {
+		return fmt.Errorf("book %q is not part of the commedia", c.Book)
+	}
+	if c.Arabic < 1 || c.Arabic > book.Cantos {
+		return fmt.Errorf("arabic must be between 1 and %d for %s", book.Cantos, book.Name)
+	}
+	if c.Verse < 1 {
+		return errors.New("verse must be a positive verse number")
+	}
+	if c.Words < 0 {
+		return errors.New("words cannot be negative")
+	}
+	if c.TextItalian == "" {
+		return errors.New("textItalian is required")
+	}
+	for id := range c.Translations {
+		if !ValidTranslationID(id) {
+			return fmt.Errorf("translation id %q is not valid", id)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// Citation points to a passage of a single canto, e.g. Inf. I, 1-12
+type Citation struct {
+	Book  string `json:"book"`
+	Canto int    `json:"canto"`
+	From  int    `json:"from"`
+	To    int    `json:"to"`
+}
+
+var citationPattern = regexp.MustCompile(`^\s*([A-Za-z]+\.?)\s*,?\s*([IVXLCivxlc]+|\d+)(?:\s*[,.:]\s*|\s+)(\d+)(?:\s*[-–]\s*(\d+))?\s*$`)
+
+// ParseCitation reads citations like "Inferno I 1-12", "Inf. I, 1-12" or "Par. 33, 145",
+// the canto can be roman or arabic and a single verse is a range of one
+func ParseCitation(text string) (Citation, error) {
+	var citation Citation
+
+	parts := citationPattern.FindStringSubmatch(text)
+	if parts == nil {
+		return citation, fmt.Errorf("%q is not a citation like Inf. I, 1-12", text)
+	}
+
+	book, ok := LookupBook(parts[1])
+	if !ok {
+		return citation, fmt.Errorf("book %q is not part of the commedia", parts[1])
+	}
+	citation.Book = book.Name
+
+	canto, err := strconv.Atoi(parts[2])
+	if err != nil {
+		canto, err = ParseRoman(parts[2])
+		if err != nil {
+			return citation, err
+		}
+	}
+	if canto < 1 || canto > book.Cantos {
+		return citation, fmt.Errorf("%s has no canto %s", book.Name, parts[2])
+	}
+	citation.Canto = canto
+
+	citation.From, _ = strconv.Atoi(parts[3])
+	citation.To = citation.From
+	if parts[4] != "" {
+		citation.To, _ = strconv.Atoi(parts[4])
+	}
+	if citation.From < 1 || citation.To < citation.From {
+		return citation, fmt.Errorf("verses %d-%d are not a valid range", citation.From, citation.To)
+	}
+
+	return citation, nil
+}
+
+func (c Citation) String() string {
+	if c.From == c.To {
+		return fmt.Sprintf("%s %s, %d", c.Book, ToRoman(c.Canto), c.From)
+	}
+	return fmt.Sprintf("%s %s, %d-%d", c.Book, ToRoman(c.Canto), c.From, c.To)
+}
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"strconv"
+	"time"
+)
+
+const (
+	CantoCreated = "CantoCreated"
+	CantoUpdated = "CantoUpdated"
+	CantoDeleted = "CantoDeleted"
+)
+
+// CantoEvent is published every time a verse in the canti collection changes.
+// Changes holds the new value of every field that was set, for a create that is every field
+type CantoEvent struct {
+	Type      string                 `json:"type"`
+	ID        string                 `json:"id"`
+	Book      string                 `json:"book"`
+	Arabic    int                    `json:"arabic"`
+	Verse     int                    `json:"verse"`
+	Changes   map[string]interface{} `json:"changes,omitempty"`
+	Removed   []string               `json:"removed,omitempty"`
+	Timestamp time.Time              `json:"timestamp"`
+}
+
+// Key identifies the verse of an event, events of the same verse share a key so they stay in order
+func (e CantoEvent) Key() string {
+	if e.Book == "" {
+		return e.ID
+	}
+	return e.Book + ":" + strconv.Itoa(e.Arabic) + ":" + strconv.Itoa(e.Verse)
+}
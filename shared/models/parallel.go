@@ -0,0 +1,53 @@
+package models
+
+import "strconv"
+
+// ParallelCanto is a whole canto with the original and a translation aligned verse by verse
+type ParallelCanto struct {
+	Book        string         `json:"book"`
+	Canto       int            `json:"canto"`
+	Roman       string         `json:"roman"`
+	Title       string         `json:"title"`
+	Translation Translation    `json:"translation"`
+	Lines       []ParallelLine `json:"lines"`
+}
+
+// ParallelLine is one verse in both languages. Number is only filled in on every third verse,
+// the way printed editions number the commedia
+type ParallelLine struct {
+	Verse       int    `json:"verse"`
+	Number      string `json:"number"`
+	Italian     string `json:"italian"`
+	Translation string `json:"translation"`
+}
+
+// AlignCanto expects the verses of one canto ordered by verse number, verses missing
+// from the translation are left empty on that side
+func AlignCanto(verses []Canto, translation Translation) ParallelCanto {
+	parallel := ParallelCanto{Translation: translation, Lines: []ParallelLine{}}
+	if len(verses) == 0 {
+		return parallel
+	}
+
+	parallel.Book = verses[0].Book
+	parallel.Canto = verses[0].Arabic
+	parallel.Roman = verses[0].Roman
+	if parallel.Roman == "" {
+		parallel.Roman = ToRoman(verses[0].Arabic)
+	}
+	parallel.Title = verses[0].Title
+
+	for _, verse := range verses {
+		text, _ := verse.Translation(translation.ID)
+		line := ParallelLine{
+			Verse:       verse.Verse,
+			Italian:     verse.TextItalian,
+			Translation: text,
+		}
+		if verse.Verse%3 == 0 {
+			line.Number = strconv.Itoa(verse.Verse)
+		}
+		parallel.Lines = append(parallel.Lines, line)
+	}
+	return parallel
+}
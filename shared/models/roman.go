@@ -0,0 +1,71 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var romanValues = map[rune]int{'I': 1, 'V': 5, 'X': 10, 'L': 50, 'C': 100}
+
+var romanNumerals = []struct {
+	value   int
+	numeral string
+}{
+	{100, "C"}, {90, "XC"}, {50, "L"}, {40, "XL"},
+	{10, "X"}, {9, "IX"}, {5, "V"}, {4, "IV"}, {1, "I"},
+}
+
+// ToRoman writes a canto number the way it is printed in the commedia, e.g. 34 becomes XXXIV
+func ToRoman(number int) string {
+	var roman strings.Builder
+	for _, n := range romanNumerals {
+		for number >= n.value {
+			roman.WriteString(n.numeral)
+			number -= n.value
+		}
+	}
+	return roman.String()
+}
+
+// ParseRoman reads a roman canto number, only numbers that are written in their canonical form are accepted
+func ParseRoman(roman string) (int, error) {
+	roman = strings.ToUpper(roman)
+
+	total := 0
+	for i, r := range roman {
+		value, ok := romanValues[r]
+		if !ok {
+			return 0, fmt.Errorf("%q is not a roman number", roman)
+		}
+		if i+1 < len(roman) && value < romanValues[rune(roman[i+1])] {
+			total -= value
+		} else {
+			total += value
+		}
+	}
+
+	if total < 1 || ToRoman(total) != roman {
+		return 0, fmt.Errorf("%q is not a roman number", roman)
+	}
+	return total, nil
+}
+
+// ParseCanto reads a canto number the way it shows up in urls: arabic (1), roman (I or i)
+// or roman with the word canto in front of it (cantoi, canto-xxxiv)
+func ParseCanto(canto string) (int, error) {
+	if arabic, err := strconv.Atoi(canto); err == nil {
+		if arabic < 1 {
+			return 0, fmt.Errorf("canto %q is not a valid number", canto)
+		}
+		return arabic, nil
+	}
+
+	roman := strings.TrimPrefix(strings.ToLower(canto), "canto")
+	roman = strings.TrimLeft(roman, "-_ ")
+	arabic, err := ParseRoman(roman)
+	if err != nil {
+		return 0, fmt.Errorf("canto %q is not a valid number", canto)
+	}
+	return arabic, nil
+}
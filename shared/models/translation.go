@@ -0,0 +1,41 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// DefaultTranslation is the translation the english text the canti started out with was moved to
+const DefaultTranslation = "default"
+
+var translationID = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// Translation describes one translation of the commedia, the text itself is kept per verse
+// in Canto.Translations under the id of the translation
+type Translation struct {
+	ID         string `bson:"_id" json:"id"`
+	Translator string `bson:"translator" json:"translator"`
+	Language   string `bson:"language" json:"language"`
+	Year       int    `bson:"year,omitempty" json:"year,omitempty"`
+	License    string `bson:"license" json:"license"`
+}
+
+// ValidTranslationID tells whether id can be used as a translation id, ids end up in field names
+// so they are kept to lowercase letters, digits and dashes
+func ValidTranslationID(id string) bool {
+	return translationID.MatchString(id)
+}
+
+func (t Translation) Validate() error {
+	if !ValidTranslationID(t.ID) {
+		return fmt.Errorf("translation id %q can only hold lowercase letters, digits and dashes", t.ID)
+	}
+	if t.Language == "" {
+		return errors.New("language is required")
+	}
+	if t.Year < 0 {
+		return errors.New("year cannot be negative")
+	}
+	return nil
+}
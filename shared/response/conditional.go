@@ -0,0 +1,81 @@
+package response
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/opentracing/opentracing-go"
+	openlog "github.com/opentracing/opentracing-go/log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CacheControl lets caches keep a response for a minute, after that they revalidate it with the ETag
+const CacheControl = "public, max-age=60"
+
+//generic method to respond with json that clients can cache, a 304 without body is sent when
+//the client already has this payload. lastModified is left out of the response when it is zero
+func RespondWithCacheableJson(w http.ResponseWriter, req *http.Request, payload interface{}, lastModified time.Time, ctx context.Context) {
+	span, _ := opentracing.StartSpanFromContext(ctx, "Response")
+	defer span.Finish()
+	span.SetTag("Method", "RespondWithCacheableJson")
+
+	response, _ := json.Marshal(payload)
+	etag := Etag(response)
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", CacheControl)
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	if notModified(req, etag, lastModified) {
+		w.WriteHeader(http.StatusNotModified)
+		span.LogFields(
+			openlog.String("http_status_code", strconv.Itoa(http.StatusNotModified)),
+			openlog.String("etag", etag),
+		)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+	span.LogFields(
+		openlog.String("http_status_code", strconv.Itoa(http.StatusOK)),
+		openlog.String("etag", etag),
+		openlog.String("body", string(response)),
+	)
+}
+
+// Etag returns a strong entity tag for a response body
+func Etag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// notModified follows RFC 7232: If-None-Match wins over If-Modified-Since when both are sent
+func notModified(req *http.Request, etag string, lastModified time.Time) bool {
+	if match := req.Header.Get("If-None-Match"); match != "" {
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == "*" || candidate == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	if since := req.Header.Get("If-Modified-Since"); since != "" && !lastModified.IsZero() {
+		t, err := http.ParseTime(since)
+		if err != nil {
+			return false
+		}
+		return !lastModified.Truncate(time.Second).After(t)
+	}
+
+	return false
+}
@@ -0,0 +1,23 @@
+package response
+
+import (
+	"context"
+	"net/http"
+)
+
+// ErrorResponse is the body every service sends back when a request could not be handled
+type ErrorResponse struct {
+	Status  int    `json:"status"`
+	Error   string `json:"error"`
+	Message string `json:"message"`
+}
+
+//generic method to respond with an error and log it to jaeger
+func RespondWithError(w http.ResponseWriter, code int, message string, ctx context.Context) {
+	body := ErrorResponse{
+		Status:  code,
+		Error:   http.StatusText(code),
+		Message: message,
+	}
+	RespondWithJson(w, code, body, ctx)
+}
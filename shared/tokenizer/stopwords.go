@@ -0,0 +1,32 @@
+package tokenizer
+
+// stopWords holds the italian stop words without accents, including the elided forms that are left
+// after splitting on an apostrophe (l'anima, ch'i', s'aperse) and the older forms dante uses (lo, ne, e')
+var stopWords = toSet(
+	// articles
+	"il", "lo", "la", "i", "gli", "le", "l", "un", "uno", "una", "gl",
+	// prepositions and their contractions with an article
+	"di", "a", "da", "in", "con", "su", "per", "tra", "fra",
+	"del", "dello", "della", "dei", "degli", "delle", "dell", "de",
+	"al", "allo", "alla", "ai", "agli", "alle", "all",
+	"dal", "dallo", "dalla", "dai", "dagli", "dalle", "dall",
+	"nel", "nello", "nella", "nei", "negli", "nelle", "nell", "ne",
+	"col", "coi", "sul", "sullo", "sulla", "sui", "sugli", "sulle", "sull",
+	// conjunctions
+	"e", "ed", "o", "od", "ma", "che", "ch", "se", "come", "com", "perche", "pero", "pur", "pure",
+	// pronouns and particles
+	"mi", "m", "ti", "t", "si", "s", "ci", "c", "vi", "v", "n",
+	"io", "tu", "lui", "lei", "noi", "voi", "loro", "egli", "ella", "esso", "essa",
+	"me", "te", "cui", "chi", "quel", "quello", "quella", "quei", "quelli", "quelle",
+	"questo", "questa", "questi", "queste", "mio", "mia", "tuo", "tua", "suo", "sua",
+	// common forms of essere and avere
+	"era", "fu", "sono", "ho", "ha", "hai", "han", "hanno", "avea", "non",
+)
+
+func toSet(words ...string) map[string]struct{} {
+	set := make(map[string]struct{}, len(words))
+	for _, word := range words {
+		set[word] = struct{}{}
+	}
+	return set
+}
@@ -0,0 +1,74 @@
+package tokenizer
+
+import (
+	"net/url"
+	"strings"
+	"unicode"
+)
+
+// Options decides which words count as the same word and which words are left out
+type Options struct {
+	// FoldAccents drops the accents so più and piu are one word
+	FoldAccents bool
+	// SkipStopWords leaves out articles, prepositions and other italian words that carry no meaning of their own
+	SkipStopWords bool
+}
+
+// ParseOptions reads the options from a query string, fold=true folds accents and stopwords=skip
+// leaves the stop words out. Words are counted as they are written when the parameters are missing
+func ParseOptions(query url.Values) Options {
+	return Options{
+		FoldAccents:   query.Get("fold") == "true",
+		SkipStopWords: query.Get("stopwords") == "skip",
+	}
+}
+
+// Tokenize splits a text in lowercase words without punctuation. An apostrophe ends an elided word
+// so l'anima gives l and anima, and dell'alta gives dell and alta
+func Tokenize(text string, options Options) []string {
+	var words []string
+	for _, word := range strings.FieldsFunc(text, IsSeparator) {
+		if word, ok := options.Apply(word); ok {
+			words = append(words, word)
+		}
+	}
+	return words
+}
+
+// Apply normalizes a single word, ok is false when the options leave the word out
+func (o Options) Apply(word string) (string, bool) {
+	word = strings.ToLower(word)
+	if o.FoldAccents {
+		word = FoldAccents(word)
+	}
+	if o.SkipStopWords && IsStopWord(word) {
+		return word, false
+	}
+	return word, word != ""
+}
+
+// IsSeparator is true for every rune that is not part of a word
+func IsSeparator(r rune) bool {
+	return !unicode.IsLetter(r)
+}
+
+var accents = map[rune]rune{
+	'à': 'a', 'á': 'a', 'è': 'e', 'é': 'e', 'ì': 'i', 'í': 'i', 'ï': 'i',
+	'ò': 'o', 'ó': 'o', 'ù': 'u', 'ú': 'u', 'ü': 'u',
+}
+
+// FoldAccents replaces accented lowercase letters with their plain letter
+func FoldAccents(text string) string {
+	return strings.Map(func(r rune) rune {
+		if plain, ok := accents[r]; ok {
+			return plain
+		}
+		return r
+	}, text)
+}
+
+// IsStopWord expects a lowercase word, accented or not
+func IsStopWord(word string) bool {
+	_, ok := stopWords[FoldAccents(word)]
+	return ok
+}
@@ -0,0 +1,69 @@
+package tokenizer
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		options Options
+		words   []string
+	}{
+		{"lowercase", "Nel Mezzo", Options{}, []string{"nel", "mezzo"}},
+		{"punctuation", "oscura, ché la diritta via era smarrita.", Options{}, []string{"oscura", "ché", "la", "diritta", "via", "era", "smarrita"}},
+		{"quotes and dashes", "«Ahi» — quanto", Options{}, []string{"ahi", "quanto"}},
+		{"elision", "l'anima", Options{}, []string{"l", "anima"}},
+		{"elision with a preposition", "dell'alta", Options{}, []string{"dell", "alta"}},
+		{"accents kept", "più perché", Options{}, []string{"più", "perché"}},
+		{"accents folded", "Più perché", Options{FoldAccents: true}, []string{"piu", "perche"}},
+		{"stop words skipped", "Nel mezzo del cammin di nostra vita", Options{SkipStopWords: true}, []string{"mezzo", "cammin", "nostra", "vita"}},
+		{"elided stop words skipped", "l'anima ch'i' vidi", Options{SkipStopWords: true}, []string{"anima", "vidi"}},
+		{"accented stop words skipped", "perché però", Options{SkipStopWords: true}, nil},
+		{"nothing", " ,.; ", Options{}, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			words := Tokenize(test.text, test.options)
+			if !reflect.DeepEqual(words, test.words) {
+				t.Errorf("expected %q, got %q", test.words, words)
+			}
+		})
+	}
+}
+
+func TestIsStopWord(t *testing.T) {
+	tests := map[string]bool{
+		"il": true, "della": true, "ch": true, "perché": true, "perche": true,
+		"selva": false, "vita": false, "anima": false,
+	}
+	for word, stop := range tests {
+		if IsStopWord(word) != stop {
+			t.Errorf("expected IsStopWord(%q) to be %v", word, stop)
+		}
+	}
+}
+
+func TestParseOptions(t *testing.T) {
+	tests := []struct {
+		query   string
+		options Options
+	}{
+		{"", Options{}},
+		{"fold=true", Options{FoldAccents: true}},
+		{"stopwords=skip", Options{SkipStopWords: true}},
+		{"fold=true&stopwords=skip", Options{FoldAccents: true, SkipStopWords: true}},
+		{"fold=false&stopwords=keep", Options{}},
+	}
+
+	for _, test := range tests {
+		query, _ := url.ParseQuery(test.query)
+		if options := ParseOptions(query); options != test.options {
+			t.Errorf("%q: expected %+v, got %+v", test.query, test.options, options)
+		}
+	}
+}